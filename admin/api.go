@@ -0,0 +1,216 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"time"
+)
+
+// The admin service is not part of the protocol buffers shared with the registry, so its messages are plain Go
+// structs sent with a JSON codec over gRPC. The codec is registered under a name of its own, not to replace any "json"
+// codec of the other gRPC services in the same binary: the admin client selects it as content subtype of its calls,
+// and the admin server forces it.
+
+const (
+	ServiceName = "sdcc.admin.Admin"
+	codecName   = "sdcc-admin-json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+type Empty struct{}
+
+// Node contains the id and the server addresses of a host
+type Node struct {
+	Id                string `json:"id"`
+	MembershipAddress string `json:"membership_address"`
+	VivaldiAddress    string `json:"vivaldi_address"`
+	GossipAddress     string `json:"gossip_address"`
+}
+
+// CoordinateReply contains the system coordinate computed by the vivaldi protocol, the application coordinate chosen
+// by the stabilizer and gossiped to the other hosts, and the state of the registration to the registry
+type CoordinateReply struct {
+	Node        Node      `json:"node"`
+	System      []float64 `json:"system"`
+	Error       float64   `json:"error"`
+	Application []float64 `json:"application"`
+	AppUpdated  time.Time `json:"app_updated"`
+	Registry    string    `json:"registry"` // "connected", "degraded" or "disconnected"
+}
+
+type Descriptor struct {
+	Node Node `json:"node"`
+	Age  int  `json:"age"`
+}
+
+type ViewReply struct {
+	Descriptors []Descriptor `json:"descriptors"`
+}
+
+// StoreItem is an application coordinate gossiped by a host, with the time it was chosen by that host
+type StoreItem struct {
+	Node       Node      `json:"node"`
+	Coordinate []float64 `json:"coordinate"`
+	Time       time.Time `json:"time"`
+}
+
+type StoreReply struct {
+	Items []StoreItem `json:"items"`
+}
+
+type NeighbourReply struct {
+	Found     bool       `json:"found"`
+	Neighbour *StoreItem `json:"neighbour,omitempty"`
+}
+
+type EstimateRequest struct {
+	NodeId string `json:"node_id"`
+}
+
+type EstimateToCoordinateRequest struct {
+	Coordinate []float64 `json:"coordinate"`
+}
+
+// EstimateReply contains the RTT predicted by the distance between the application coordinate of the current host
+// and the requested one
+type EstimateReply struct {
+	NodeId       string    `json:"node_id,omitempty"`
+	RttMs        float64   `json:"rtt_ms"`
+	ErrorBoundMs float64   `json:"error_bound_ms"`
+	Time         time.Time `json:"time"` // time the coordinate of the requested node was chosen by that node
+	AgeMs        float64   `json:"age_ms"`
+}
+
+// NearestRequest asks for the K nodes closest to the coordinate, or to the application coordinate of the host if it
+// is empty. A K of 0 returns all the nodes, and a MaxAgeMs greater than 0 skips the older coordinates.
+type NearestRequest struct {
+	Coordinate []float64 `json:"coordinate,omitempty"`
+	K          int       `json:"k"`
+	MaxAgeMs   int64     `json:"max_age_ms,omitempty"`
+}
+
+// NearestItem is a stored coordinate with the RTT predicted to its node
+type NearestItem struct {
+	StoreItem
+	RttMs float64 `json:"rtt_ms"`
+}
+
+// WithinRequest asks for the nodes whose predicted RTT from the coordinate, or from the application coordinate of the
+// host if it is empty, is up to MaxRttMs
+type WithinRequest struct {
+	Coordinate []float64 `json:"coordinate,omitempty"`
+	MaxRttMs   float64   `json:"max_rtt_ms"`
+}
+
+// NearestReply contains the nodes answering a nearest or within query, sorted by predicted RTT
+type NearestReply struct {
+	Items []NearestItem `json:"items"`
+}
+
+// WatchRequest asks for the coordinates every time they change, checking them at the given interval
+type WatchRequest struct {
+	IntervalMs int64 `json:"interval_ms"`
+}
+
+// AdminServer is the server API of the admin service
+type AdminServer interface {
+	GetCoordinate(context.Context, *Empty) (*CoordinateReply, error)
+	GetView(context.Context, *Empty) (*ViewReply, error)
+	GetStore(context.Context, *Empty) (*StoreReply, error)
+	GetNeighbour(context.Context, *Empty) (*NeighbourReply, error)
+	EstimateRTT(context.Context, *EstimateRequest) (*EstimateReply, error)
+	EstimateRTTToCoordinate(context.Context, *EstimateToCoordinateRequest) (*EstimateReply, error)
+	GetNearest(context.Context, *NearestRequest) (*NearestReply, error)
+	GetWithin(context.Context, *WithinRequest) (*NearestReply, error)
+	WatchCoordinate(*WatchRequest, CoordinateStream) error
+}
+
+// CoordinateStream is the server side of the stream sending the coordinate changes
+type CoordinateStream interface {
+	Send(*CoordinateReply) error
+	Context() context.Context
+}
+
+type coordinateStream struct {
+	grpc.ServerStream
+}
+
+func (s *coordinateStream) Send(reply *CoordinateReply) error {
+	return s.ServerStream.SendMsg(reply)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetCoordinate", Handler: unaryHandler("GetCoordinate", AdminServer.GetCoordinate)},
+		{MethodName: "GetView", Handler: unaryHandler("GetView", AdminServer.GetView)},
+		{MethodName: "GetStore", Handler: unaryHandler("GetStore", AdminServer.GetStore)},
+		{MethodName: "GetNeighbour", Handler: unaryHandler("GetNeighbour", AdminServer.GetNeighbour)},
+		{MethodName: "EstimateRTT", Handler: unaryHandler("EstimateRTT", AdminServer.EstimateRTT)},
+		{MethodName: "EstimateRTTToCoordinate", Handler: unaryHandler("EstimateRTTToCoordinate", AdminServer.EstimateRTTToCoordinate)},
+		{MethodName: "GetNearest", Handler: unaryHandler("GetNearest", AdminServer.GetNearest)},
+		{MethodName: "GetWithin", Handler: unaryHandler("GetWithin", AdminServer.GetWithin)},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchCoordinate", Handler: watchCoordinateHandler, ServerStreams: true},
+	},
+	Metadata: "admin",
+}
+
+// RegisterAdminServer registers the admin service on the gRPC server
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+type methodHandler = func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error)
+
+// unaryHandler decodes the request and calls the method of the server, as the handlers generated by protoc do
+func unaryHandler[Req, Reply any](method string,
+	call func(AdminServer, context.Context, *Req) (*Reply, error)) methodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(AdminServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod(method)}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(AdminServer), ctx, req.(*Req))
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+func watchCoordinateHandler(srv any, stream grpc.ServerStream) error {
+	in := new(WatchRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(AdminServer).WatchCoordinate(in, &coordinateStream{stream})
+}
+
+func fullMethod(method string) string {
+	return "/" + ServiceName + "/" + method
+}
@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"google.golang.org/grpc/encoding"
+	"testing"
+)
+
+func TestCodecDoesNotReplaceJson(t *testing.T) {
+	if codec := encoding.GetCodec(codecName); codec == nil {
+		t.Fatalf("codec %q is not registered", codecName)
+	}
+	if codec := encoding.GetCodec("json"); codec != nil {
+		t.Errorf("codec %T replaced the one named json", codec)
+	}
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"context"
+	"google.golang.org/grpc"
+)
+
+// AdminClient is the client API of the admin service
+type AdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) *AdminClient {
+	return &AdminClient{cc: cc}
+}
+
+func (c *AdminClient) GetCoordinate(ctx context.Context, opts ...grpc.CallOption) (*CoordinateReply, error) {
+	return invoke[CoordinateReply](ctx, c.cc, "GetCoordinate", &Empty{}, opts...)
+}
+
+func (c *AdminClient) GetView(ctx context.Context, opts ...grpc.CallOption) (*ViewReply, error) {
+	return invoke[ViewReply](ctx, c.cc, "GetView", &Empty{}, opts...)
+}
+
+func (c *AdminClient) GetStore(ctx context.Context, opts ...grpc.CallOption) (*StoreReply, error) {
+	return invoke[StoreReply](ctx, c.cc, "GetStore", &Empty{}, opts...)
+}
+
+func (c *AdminClient) GetNeighbour(ctx context.Context, opts ...grpc.CallOption) (*NeighbourReply, error) {
+	return invoke[NeighbourReply](ctx, c.cc, "GetNeighbour", &Empty{}, opts...)
+}
+
+func (c *AdminClient) EstimateRTT(ctx context.Context, in *EstimateRequest, opts ...grpc.CallOption) (*EstimateReply, error) {
+	return invoke[EstimateReply](ctx, c.cc, "EstimateRTT", in, opts...)
+}
+
+func (c *AdminClient) EstimateRTTToCoordinate(ctx context.Context, in *EstimateToCoordinateRequest,
+	opts ...grpc.CallOption) (*EstimateReply, error) {
+	return invoke[EstimateReply](ctx, c.cc, "EstimateRTTToCoordinate", in, opts...)
+}
+
+func (c *AdminClient) GetNearest(ctx context.Context, in *NearestRequest, opts ...grpc.CallOption) (*NearestReply, error) {
+	return invoke[NearestReply](ctx, c.cc, "GetNearest", in, opts...)
+}
+
+func (c *AdminClient) GetWithin(ctx context.Context, in *WithinRequest, opts ...grpc.CallOption) (*NearestReply, error) {
+	return invoke[NearestReply](ctx, c.cc, "GetWithin", in, opts...)
+}
+
+// WatchCoordinate returns the stream of the coordinate changes, which ends when ctx is done
+func (c *AdminClient) WatchCoordinate(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (*CoordinateWatcher, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], fullMethod("WatchCoordinate"), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &CoordinateWatcher{stream: stream}, nil
+}
+
+// CoordinateWatcher is the client side of the stream sending the coordinate changes
+type CoordinateWatcher struct {
+	stream grpc.ClientStream
+}
+
+// Recv blocks until the coordinates change, returning io.EOF when the server closes the stream
+func (w *CoordinateWatcher) Recv() (*CoordinateReply, error) {
+	out := new(CoordinateReply)
+	if err := w.stream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func invoke[Reply any](ctx context.Context, cc grpc.ClientConnInterface, method string, in any,
+	opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	if err := cc.Invoke(ctx, fullMethod(method), in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,291 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cm "github.com/AlessandroFinocchi/sdcc_common/model"
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	u "github.com/AlessandroFinocchi/sdcc_common/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	m "sdcc_host/model"
+	"sdcc_host/services"
+	uh "sdcc_host/utils"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWatchInterval = time.Second
+	minWatchInterval     = 100 * time.Millisecond
+)
+
+// Host is the running host inspected by the admin service. Its methods must be safe to call concurrently with the
+// protocols, and must return copies of the state.
+type Host interface {
+	Node() *pb.Node
+	Coordinate() (m.Coordinate, float64)
+	AppCoordinate() (m.Coordinate, time.Time)
+	PartialView() *m.PartialView
+	Store() m.Store
+	EstimateRTT(nodeId string) (services.Estimate, error)
+	EstimateRTTToCoordinate(value []float64) (services.Estimate, error)
+	Nearest(value []float64, k int, maxAge time.Duration) ([]m.RankedCoordinate, error)
+	Within(value []float64, maxRtt time.Duration) ([]m.RankedCoordinate, error)
+	RegistryState() services.RegistryState
+}
+
+// Server serves the admin service on its own port, so that the host can be inspected without turning on the logs
+type Server struct {
+	host     Host
+	server   *grpc.Server
+	done     chan struct{} // closed when stopping, to end the watch streams
+	stopOnce sync.Once
+}
+
+func NewServer(host Host) *Server {
+	return &Server{
+		host: host,
+		done: make(chan struct{}),
+	}
+}
+
+func (s *Server) GetCoordinate(ctx context.Context, _ *Empty) (*CoordinateReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.coordinate(), nil
+}
+
+func (s *Server) coordinate() *CoordinateReply {
+	sysCoord, sysError := s.host.Coordinate()
+	appCoord, appUpdated := s.host.AppCoordinate()
+
+	return &CoordinateReply{
+		Node:        nodeFromProto(s.host.Node()),
+		System:      sysCoord.Proto(sysError).Value,
+		Error:       sysError,
+		Application: appCoord.Proto(0).Value,
+		AppUpdated:  appUpdated,
+		Registry:    s.host.RegistryState().String(),
+	}
+}
+
+func (s *Server) GetView(ctx context.Context, _ *Empty) (*ViewReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	descriptors := s.host.PartialView().Descriptors()
+	reply := &ViewReply{Descriptors: make([]Descriptor, 0, len(descriptors))}
+	for _, desc := range descriptors {
+		reply.Descriptors = append(reply.Descriptors, Descriptor{Node: nodeFromProto(desc.Node), Age: desc.Age})
+	}
+
+	return reply, nil
+}
+
+func (s *Server) GetStore(ctx context.Context, _ *Empty) (*StoreReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	items := s.host.Store().Items()
+	reply := &StoreReply{Items: make([]StoreItem, 0, len(items))}
+	for _, item := range items {
+		reply.Items = append(reply.Items, storeItemFromGossip(item))
+	}
+
+	return reply, nil
+}
+
+func (s *Server) GetNeighbour(ctx context.Context, _ *Empty) (*NeighbourReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	neighbour, ok := s.host.Store().Neighbour()
+	if !ok {
+		return &NeighbourReply{Found: false}, nil
+	}
+	item := storeItemFromGossip(neighbour)
+
+	return &NeighbourReply{Found: true, Neighbour: &item}, nil
+}
+
+// EstimateRTT predicts the RTT to the node from the distance between the application coordinates
+func (s *Server) EstimateRTT(ctx context.Context, request *EstimateRequest) (*EstimateReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	estimate, err := s.host.EstimateRTT(request.NodeId)
+	if errors.Is(err, services.ErrUnknownNode) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	return estimateReply(estimate), nil
+}
+
+// EstimateRTTToCoordinate predicts the RTT to a host with the given application coordinate
+func (s *Server) EstimateRTTToCoordinate(ctx context.Context, request *EstimateToCoordinateRequest) (*EstimateReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	estimate, err := s.host.EstimateRTTToCoordinate(request.Coordinate)
+	if errors.Is(err, services.ErrInvalidCoordinate) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	return estimateReply(estimate), nil
+}
+
+// GetNearest returns the nodes closest to the requested coordinate, sorted by predicted RTT
+func (s *Server) GetNearest(ctx context.Context, request *NearestRequest) (*NearestReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+	if request.K < 0 || request.MaxAgeMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "k and max_age_ms must not be negative")
+	}
+
+	nearest, err := s.host.Nearest(request.Coordinate, request.K, time.Duration(request.MaxAgeMs)*time.Millisecond)
+	if errors.Is(err, services.ErrInvalidCoordinate) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	return nearestReply(nearest), nil
+}
+
+// GetWithin returns the nodes whose predicted RTT from the requested coordinate is up to the threshold, sorted by
+// predicted RTT
+func (s *Server) GetWithin(ctx context.Context, request *WithinRequest) (*NearestReply, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+	if request.MaxRttMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max_rtt_ms must not be negative")
+	}
+
+	within, err := s.host.Within(request.Coordinate, uh.DurationFromMs(request.MaxRttMs))
+	if errors.Is(err, services.ErrInvalidCoordinate) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	return nearestReply(within), nil
+}
+
+// WatchCoordinate sends the coordinates when the stream starts and then every time they or the registry state change,
+// until the client cancels the stream or the server stops
+func (s *Server) WatchCoordinate(request *WatchRequest, stream CoordinateStream) error {
+	interval := defaultWatchInterval
+	if request.IntervalMs > 0 {
+		interval = max(time.Duration(request.IntervalMs)*time.Millisecond, minWatchInterval)
+	}
+
+	last := s.coordinate()
+	if err := stream.Send(last); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.done:
+			return status.Error(codes.Unavailable, "admin server is stopping")
+		case <-ticker.C:
+			current := s.coordinate()
+			if current.Error == last.Error && slices.Equal(current.System, last.System) &&
+				slices.Equal(current.Application, last.Application) && current.Registry == last.Registry {
+				continue
+			}
+			if err := stream.Send(current); err != nil {
+				return err
+			}
+			last = current
+		}
+	}
+}
+
+// StartServer starts serving on the given port (0 for an ephemeral one) and returns the port of the server
+func (s *Server) StartServer(port uint) (uint32, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create admin listener: %w", err)
+	}
+
+	s.server = grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}))
+	RegisterAdminServer(s.server, s)
+
+	go func() {
+		if errS := s.server.Serve(lis); errS != nil {
+			log.Fatalf("Failed to serve: %v", errS)
+		}
+	}()
+
+	return uint32(lis.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Stop gracefully stops the admin server, waiting for the pending requests
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+	if s.server != nil {
+		s.server.GracefulStop()
+	}
+}
+
+func nodeFromProto(node *pb.Node) Node {
+	return Node{
+		Id:                node.GetId(),
+		MembershipAddress: cm.ProtoNodeMembershipAddress(node),
+		VivaldiAddress:    cm.ProtoNodeVivaldiAddress(node),
+		GossipAddress:     cm.ProtoNodeGossipAddress(node),
+	}
+}
+
+func nearestReply(items []m.RankedCoordinate) *NearestReply {
+	reply := &NearestReply{Items: make([]NearestItem, 0, len(items))}
+	for _, item := range items {
+		reply.Items = append(reply.Items, NearestItem{
+			StoreItem: storeItemFromGossip(item.GossipCoordinate),
+			RttMs:     item.Distance,
+		})
+	}
+	return reply
+}
+
+func estimateReply(estimate services.Estimate) *EstimateReply {
+	return &EstimateReply{
+		NodeId:       estimate.NodeId,
+		RttMs:        uh.Milliseconds(estimate.Rtt),
+		ErrorBoundMs: uh.Milliseconds(estimate.ErrorBound),
+		Time:         estimate.Time,
+		AgeMs:        uh.Milliseconds(estimate.Age),
+	}
+}
+
+func storeItemFromGossip(item m.GossipCoordinate) StoreItem {
+	return StoreItem{
+		Node:       nodeFromProto(item.Node()),
+		Coordinate: item.Coord().Proto(0).Value,
+		Time:       item.Age(),
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sdcc_host/admin"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:50100", "Address of the host admin server")
+	timeout  = flag.Duration("timeout", 5*time.Second, "Timeout of each request, except watch")
+	interval = flag.Duration("interval", time.Second, "Interval between the coordinate checks of watch")
+	asJson   = flag.Bool("json", false, "Print the replies as JSON")
+	k        = flag.Int("k", 5, "Number of nodes returned by nearest, 0 for all of them")
+	maxAge   = flag.Duration("max_age", 0, "Maximum age of the coordinates returned by nearest, 0 for any age")
+)
+
+const usage = `Usage: sdcc_hostctl [flags] <command> [args]
+
+Commands:
+  coord              print the system coordinate, its error, the application coordinate and the registry state
+  view               print the partial view descriptors with their ages
+  store              print the application coordinates gossiped by the other hosts
+  neighbour          print the nearest host to the application coordinate
+  estimate <nodeId>  print the RTT to the node predicted by the application coordinates
+  estimate-coord <values...>
+                     print the RTT predicted to a host with the given application coordinate
+  nearest [values...]
+                     print the k nodes closest to the given application coordinate, or to the one of the host
+  within <maxRttMs> [values...]
+                     print the nodes within the RTT from the given application coordinate, or from the one of the host
+  watch              print the coordinates every time they or the registry state change, until interrupted
+
+Flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		_, _ = fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+	client := admin.NewAdminClient(conn)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	if command == "watch" {
+		err = watch(ctx, client)
+	} else {
+		ctxT, cancel := context.WithTimeout(ctx, *timeout)
+		err = run(ctxT, client, command, args)
+		cancel()
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", command, err)
+	}
+}
+
+func run(ctx context.Context, client *admin.AdminClient, command string, args []string) error {
+	switch command {
+	case "coord":
+		reply, err := client.GetCoordinate(ctx)
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) { printCoordinate(w, reply) })
+	case "view":
+		reply, err := client.GetView(ctx)
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) {
+			_, _ = fmt.Fprintln(w, "ID\tMEMBERSHIP\tVIVALDI\tGOSSIP\tAGE")
+			for _, desc := range reply.Descriptors {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", desc.Node.Id, desc.Node.MembershipAddress,
+					desc.Node.VivaldiAddress, desc.Node.GossipAddress, desc.Age)
+			}
+		})
+	case "store":
+		reply, err := client.GetStore(ctx)
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) {
+			_, _ = fmt.Fprintln(w, "ID\tMEMBERSHIP\tCOORDINATE\tTIME")
+			for _, item := range reply.Items {
+				printStoreItem(w, item)
+			}
+		})
+	case "neighbour":
+		reply, err := client.GetNeighbour(ctx)
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) {
+			if !reply.Found {
+				_, _ = fmt.Fprintln(w, "No neighbour found")
+				return
+			}
+			_, _ = fmt.Fprintln(w, "ID\tMEMBERSHIP\tCOORDINATE\tTIME")
+			printStoreItem(w, *reply.Neighbour)
+		})
+	case "estimate":
+		if len(args) != 1 {
+			return errors.New("usage: estimate <nodeId>")
+		}
+		reply, err := client.EstimateRTT(ctx, &admin.EstimateRequest{NodeId: args[0]})
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) {
+			_, _ = fmt.Fprintf(w, "Node:\t%s\n", reply.NodeId)
+			printEstimate(w, reply)
+			_, _ = fmt.Fprintf(w, "Coordinate time:\t%s (%s ago)\n", reply.Time.Format(time.RFC3339),
+				(time.Duration(reply.AgeMs) * time.Millisecond).Round(time.Second))
+		})
+	case "estimate-coord":
+		if len(args) == 0 {
+			return errors.New("usage: estimate-coord <values...>")
+		}
+		values, err := parseValues(args)
+		if err != nil {
+			return err
+		}
+		reply, err := client.EstimateRTTToCoordinate(ctx, &admin.EstimateToCoordinateRequest{Coordinate: values})
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) { printEstimate(w, reply) })
+	case "nearest":
+		values, err := parseValues(args)
+		if err != nil {
+			return err
+		}
+		reply, err := client.GetNearest(ctx, &admin.NearestRequest{
+			Coordinate: values,
+			K:          *k,
+			MaxAgeMs:   maxAge.Milliseconds(),
+		})
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) { printNearest(w, reply) })
+	case "within":
+		if len(args) == 0 {
+			return errors.New("usage: within <maxRttMs> [values...]")
+		}
+		maxRtt, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return fmt.Errorf("invalid RTT %q", args[0])
+		}
+		values, err := parseValues(args[1:])
+		if err != nil {
+			return err
+		}
+		reply, err := client.GetWithin(ctx, &admin.WithinRequest{Coordinate: values, MaxRttMs: maxRtt})
+		if err != nil {
+			return err
+		}
+		return output(reply, func(w io.Writer) { printNearest(w, reply) })
+	default:
+		return errors.New("unknown command, run sdcc_hostctl -h for the list of commands")
+	}
+}
+
+func watch(ctx context.Context, client *admin.AdminClient) error {
+	watcher, err := client.WatchCoordinate(ctx, &admin.WatchRequest{IntervalMs: interval.Milliseconds()})
+	if err != nil {
+		return err
+	}
+
+	for {
+		reply, errR := watcher.Recv()
+		if ctx.Err() != nil || errors.Is(errR, io.EOF) {
+			return nil
+		}
+		if errR != nil {
+			return errR
+		}
+		if err = output(reply, func(w io.Writer) {
+			_, _ = fmt.Fprintf(w, "Time:\t%s\n", time.Now().Format(time.RFC3339))
+			printCoordinate(w, reply)
+			_, _ = fmt.Fprintln(w)
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// output prints the reply as JSON or, by default, as a table written by printTable
+func output(reply any, printTable func(w io.Writer)) error {
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reply)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	printTable(w)
+	return w.Flush()
+}
+
+func printCoordinate(w io.Writer, reply *admin.CoordinateReply) {
+	_, _ = fmt.Fprintf(w, "Node:\t%s\n", reply.Node.Id)
+	_, _ = fmt.Fprintf(w, "System coordinate:\t%v\n", reply.System)
+	_, _ = fmt.Fprintf(w, "Error:\t%f\n", reply.Error)
+	_, _ = fmt.Fprintf(w, "Application coordinate:\t%v\n", reply.Application)
+	_, _ = fmt.Fprintf(w, "Application updated:\t%s\n", reply.AppUpdated.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(w, "Registry:\t%s\n", reply.Registry)
+}
+
+func printEstimate(w io.Writer, reply *admin.EstimateReply) {
+	_, _ = fmt.Fprintf(w, "RTT:\t%.3f ms ± %.3f ms\n", reply.RttMs, reply.ErrorBoundMs)
+}
+
+func printNearest(w io.Writer, reply *admin.NearestReply) {
+	_, _ = fmt.Fprintln(w, "ID\tMEMBERSHIP\tCOORDINATE\tTIME\tRTT")
+	for _, item := range reply.Items {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%.3f ms\n", item.Node.Id, item.Node.MembershipAddress,
+			item.Coordinate, item.Time.Format(time.RFC3339), item.RttMs)
+	}
+}
+
+func parseValues(args []string) ([]float64, error) {
+	values := make([]float64, 0, len(args))
+	for _, arg := range args {
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coordinate value %q", arg)
+		}
+		values = append(values, f)
+	}
+	return values, nil
+}
+
+func printStoreItem(w io.Writer, item admin.StoreItem) {
+	_, _ = fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", item.Node.Id, item.Node.MembershipAddress, item.Coordinate,
+		item.Time.Format(time.RFC3339))
+}
@@ -0,0 +1,440 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultPath = "config.ini"
+
+// HostConfig contains the typed values of every section of the host configuration file
+type HostConfig struct {
+	Context       ContextConfig
+	Membership    MembershipConfig
+	Vivaldi       VivaldiConfig
+	VivaldiGossip VivaldiGossipConfig
+	Registry      RegistryConfig
+	Bootstrap     BootstrapConfig
+	Results       ResultsConfig
+	State         StateConfig
+}
+
+type ContextConfig struct {
+	Deadline time.Duration // duration of an host context
+}
+
+type MembershipConfig struct {
+	ViewSelection    string        // "blind", "healer" or "swapper"
+	SamplingInterval time.Duration // interval between two membership samplings
+	C                int           // number of nodes in the membership list
+}
+
+type VivaldiConfig struct {
+	SamplingInterval     time.Duration // interval between two vivaldi samplings
+	CoordinateSpace      string        // "euclidean", "height_euclidean", "spherical" or "hyperbolic"
+	CoordinateDimensions int           // dimensions of coordinate vector
+	SphereRadius         float64       // radius in ms of the sphere, for the spherical space
+	Curvature            float64       // negative curvature in 1/ms^2, for the hyperbolic space
+	Cc                   float64       // fraction of node's estimated error, to compute time-step
+	Ce                   float64       // local error moving average ratio
+	GravityRho           float64       // distance in ms at which gravity pulls coordinates by 1 ms, 0 to disable gravity
+	FilterType           string        // "mp", "ewma" or "raw"
+	H                    int           // history window width for mp filter
+	P                    float64       // percentile for mp filter
+	WindowSize           int           // size of the window for app-lev coordinates updates heuristics
+	Tau                  float64       // threshold in ms for app-lev coordinates updates heuristics
+	EpsilonR             float64       // relative error for app-lev coordinates updates heuristics
+	RttMode              string        // "grpc" to time the coordinate pulls, "udp" to time probes or "tcp_info" for the kernel RTT
+	ProbePort            int           // UDP port of the probe service of every host, 0 for the number of its vivaldi port
+	ProbeCount           int           // number of probes sent for each sample
+	ProbeAggregate       string        // "min" or "median" of the RTTs of the probes
+	ProbeTimeout         time.Duration // time after which a probe is considered lost
+	KernelMaxRttVar      float64       // maximum ratio of tcpi_rttvar to tcpi_rtt of a kernel sample, 0 to keep all of them
+}
+
+type VivaldiGossipConfig struct {
+	SamplingInterval  time.Duration // interval between two gossip rounds
+	FeedbackCounter   int           // maximum number of feedbacks to be sent
+	FeedbackCoordsNum int           // number of coordinates to be sent in feedbacks
+	Retention         time.Duration // time after which a coordinate is forgotten in the store
+	RetentionInterval time.Duration // time between two checks on retention
+}
+
+type RegistryConfig struct {
+	Addresses       []string      // addresses of the registry replicas, tried in order
+	ConnectDeadline time.Duration // maximum time spent trying to connect to the registry
+	BackoffInitial  time.Duration // backoff after the first round of failed connection attempts
+	BackoffMax      time.Duration // maximum backoff between two rounds of connection attempts
+
+	HeartbeatInterval time.Duration // interval between two heartbeats
+	HeartbeatTimeout  time.Duration // time after which a heartbeat is considered failed
+
+	ServerPort   int           // port of the registry server, when running in registry mode
+	NodeTTL      time.Duration // time after the last heartbeat after which the registry forgets a node
+	ConnectNodes int           // maximum number of nodes returned by the registry on connection
+}
+
+type BootstrapConfig struct {
+	Mode         string        // "registry", "static" or "dns"
+	Seeds        []string      // membership addresses of the seed nodes, for static mode
+	DnsName      string        // name whose A records are the seed nodes, for dns mode
+	DnsPort      int           // membership port of the seed nodes, for dns mode
+	ProbeTimeout time.Duration // time after which a seed node is considered unreachable
+}
+
+type ResultsConfig struct {
+	Format string // "csv", "jsonl" or "none"
+	Path   string // path of the result file
+	Naming string // "append" to the same file at every run, or "per_run" to add the start time to the file name
+}
+
+type StateConfig struct {
+	Enabled  bool          // whether the state is saved and restored across restarts
+	Path     string        // path of the state file
+	Interval time.Duration // interval between two saves, besides the one on shutdown
+	MaxAge   time.Duration // age after which a saved state is stale and the host starts fresh
+}
+
+// Load reads the configuration file at path, parses every key and validates the resulting values
+func Load(path string) (*HostConfig, error) {
+	file, err := ini.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	cfg, err := parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func parse(file *ini.File) (*HostConfig, error) {
+	r := &reader{file: file}
+	cfg := &HostConfig{
+		Context: ContextConfig{
+			Deadline: r.seconds("context", "deadline"),
+		},
+		Membership: MembershipConfig{
+			ViewSelection:    r.string("membership", "view_selection"),
+			SamplingInterval: r.seconds("membership", "sampling_interval"),
+			C:                r.int("membership", "c"),
+		},
+		Vivaldi: VivaldiConfig{
+			SamplingInterval:     r.seconds("vivaldi", "sampling_interval"),
+			CoordinateSpace:      r.string("vivaldi", "coordinate_space"),
+			CoordinateDimensions: r.int("vivaldi", "coordinate_dimensions"),
+			SphereRadius:         r.float("vivaldi", "sphere_radius"),
+			Curvature:            r.float("vivaldi", "curvature"),
+			Cc:                   r.float("vivaldi", "cc"),
+			Ce:                   r.float("vivaldi", "ce"),
+			GravityRho:           r.float("vivaldi", "gravity_rho"),
+			FilterType:           r.string("vivaldi", "filter_type"),
+			H:                    r.int("vivaldi", "h"),
+			P:                    r.float("vivaldi", "p"),
+			WindowSize:           r.int("vivaldi", "windowSize"),
+			Tau:                  r.float("vivaldi", "tau"),
+			EpsilonR:             r.float("vivaldi", "epsilon_r"),
+			RttMode:              r.string("vivaldi", "rtt_mode"),
+			ProbePort:            r.int("vivaldi", "probe_port"),
+			ProbeCount:           r.int("vivaldi", "probe_count"),
+			ProbeAggregate:       r.string("vivaldi", "probe_aggregate"),
+			ProbeTimeout:         r.milliseconds("vivaldi", "probe_timeout_ms"),
+			KernelMaxRttVar:      r.float("vivaldi", "kernel_max_rttvar"),
+		},
+		VivaldiGossip: VivaldiGossipConfig{
+			SamplingInterval:  r.seconds("vivaldi_gossip", "sampling_interval"),
+			FeedbackCounter:   r.int("vivaldi_gossip", "feedback_counter"),
+			FeedbackCoordsNum: r.int("vivaldi_gossip", "feedback_coords_num"),
+			Retention:         r.seconds("vivaldi_gossip", "retention_seconds"),
+			RetentionInterval: r.seconds("vivaldi_gossip", "retention_interval"),
+		},
+		Registry: RegistryConfig{
+			Addresses:       r.list("registry", "addresses"),
+			ConnectDeadline: r.seconds("registry", "connect_deadline"),
+			BackoffInitial:  r.milliseconds("registry", "backoff_initial_ms"),
+			BackoffMax:      r.milliseconds("registry", "backoff_max_ms"),
+
+			HeartbeatInterval: r.seconds("registry", "heartbeat_interval"),
+			HeartbeatTimeout:  r.seconds("registry", "heartbeat_timeout"),
+
+			ServerPort:   r.int("registry", "server_port"),
+			NodeTTL:      r.seconds("registry", "node_ttl"),
+			ConnectNodes: r.int("registry", "connect_nodes"),
+		},
+		Bootstrap: BootstrapConfig{
+			Mode:         r.string("bootstrap", "mode"),
+			Seeds:        r.list("bootstrap", "seeds"),
+			DnsName:      r.string("bootstrap", "dns_name"),
+			DnsPort:      r.int("bootstrap", "dns_port"),
+			ProbeTimeout: r.seconds("bootstrap", "probe_timeout"),
+		},
+		Results: ResultsConfig{
+			Format: r.string("results", "format"),
+			Path:   r.string("results", "path"),
+			Naming: r.string("results", "naming"),
+		},
+		State: StateConfig{
+			Enabled:  r.bool("state", "enabled"),
+			Path:     r.string("state", "path"),
+			Interval: r.seconds("state", "interval"),
+			MaxAge:   r.seconds("state", "max_age"),
+		},
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that every value is in its range and that related values are consistent with each other
+func (c *HostConfig) Validate() error {
+	checks := []struct {
+		ok      bool
+		section string
+		key     string
+		reason  string
+	}{
+		{c.Context.Deadline > 0, "context", "deadline", "must be positive"},
+
+		{oneOf(c.Membership.ViewSelection, "blind", "healer", "swapper"), "membership", "view_selection", `must be "blind", "healer" or "swapper"`},
+		{c.Membership.SamplingInterval > 0, "membership", "sampling_interval", "must be positive"},
+		{c.Membership.C > 0, "membership", "c", "must be positive"},
+
+		{c.Vivaldi.SamplingInterval > 0, "vivaldi", "sampling_interval", "must be positive"},
+		{oneOf(c.Vivaldi.CoordinateSpace, "euclidean", "height_euclidean", "spherical", "hyperbolic"), "vivaldi", "coordinate_space", `must be "euclidean", "height_euclidean", "spherical" or "hyperbolic"`},
+		{c.Vivaldi.CoordinateDimensions > 0, "vivaldi", "coordinate_dimensions", "must be positive"},
+		{c.Vivaldi.SphereRadius > 0, "vivaldi", "sphere_radius", "must be positive"},
+		{c.Vivaldi.Curvature < 0, "vivaldi", "curvature", "must be negative"},
+		{c.Vivaldi.Cc > 0 && c.Vivaldi.Cc <= 1, "vivaldi", "cc", "must be in (0, 1]"},
+		{c.Vivaldi.Ce > 0 && c.Vivaldi.Ce <= 1, "vivaldi", "ce", "must be in (0, 1]"},
+		{c.Vivaldi.GravityRho >= 0, "vivaldi", "gravity_rho", "must not be negative"},
+		// the sphere has no origin, and its bounded coordinates cannot drift away
+		{c.Vivaldi.GravityRho == 0 || c.Vivaldi.CoordinateSpace != "spherical", "vivaldi", "gravity_rho", `must be 0 in the "spherical" space`},
+		{oneOf(c.Vivaldi.FilterType, "mp", "ewma", "raw"), "vivaldi", "filter_type", `must be "mp", "ewma" or "raw"`},
+		{c.Vivaldi.H > 0, "vivaldi", "h", "must be positive"},
+		{c.Vivaldi.P >= 0 && c.Vivaldi.P < 100, "vivaldi", "p", "must be in [0, 100)"},
+		{c.Vivaldi.WindowSize > 0, "vivaldi", "windowSize", "must be positive"},
+		{c.Vivaldi.Tau >= 0, "vivaldi", "tau", "must not be negative"},
+		{c.Vivaldi.EpsilonR > 0, "vivaldi", "epsilon_r", "must be positive"},
+		{oneOf(c.Vivaldi.RttMode, "grpc", "udp", "tcp_info"), "vivaldi", "rtt_mode", `must be "grpc", "udp" or "tcp_info"`},
+		{c.Vivaldi.ProbePort >= 0 && c.Vivaldi.ProbePort < 65536, "vivaldi", "probe_port", "must be a valid port or 0"},
+		{c.Vivaldi.ProbeCount > 0, "vivaldi", "probe_count", "must be positive"},
+		{oneOf(c.Vivaldi.ProbeAggregate, "min", "median"), "vivaldi", "probe_aggregate", `must be "min" or "median"`},
+		{c.Vivaldi.ProbeTimeout > 0, "vivaldi", "probe_timeout_ms", "must be positive"},
+		// all the probes of a sample must fit in the sampling interval
+		{c.Vivaldi.RttMode != "udp" || time.Duration(c.Vivaldi.ProbeCount)*c.Vivaldi.ProbeTimeout < c.Vivaldi.SamplingInterval, "vivaldi", "probe_timeout_ms", "times probe_count must be less than sampling_interval in udp mode"},
+		{c.Vivaldi.KernelMaxRttVar >= 0, "vivaldi", "kernel_max_rttvar", "must not be negative"},
+
+		{c.VivaldiGossip.SamplingInterval > 0, "vivaldi_gossip", "sampling_interval", "must be positive"},
+		{c.VivaldiGossip.FeedbackCounter > 0, "vivaldi_gossip", "feedback_counter", "must be positive"},
+		{c.VivaldiGossip.FeedbackCoordsNum > 0, "vivaldi_gossip", "feedback_coords_num", "must be positive"},
+		// the stabilizer gossips the app coordinate every retention_seconds/4 seconds
+		{c.VivaldiGossip.Retention >= 4*time.Second, "vivaldi_gossip", "retention_seconds", "must be at least 4"},
+		{c.VivaldiGossip.RetentionInterval > 0, "vivaldi_gossip", "retention_interval", "must be positive"},
+		{c.VivaldiGossip.RetentionInterval <= c.VivaldiGossip.Retention, "vivaldi_gossip", "retention_interval", "must not be greater than retention_seconds"},
+
+		{c.Bootstrap.Mode != "registry" || len(c.Registry.Addresses) > 0, "registry", "addresses", "must contain at least one address in registry bootstrap mode"},
+		{c.Registry.ConnectDeadline > 0, "registry", "connect_deadline", "must be positive"},
+		{c.Registry.BackoffInitial > 0, "registry", "backoff_initial_ms", "must be positive"},
+		{c.Registry.BackoffMax >= c.Registry.BackoffInitial, "registry", "backoff_max_ms", "must not be less than backoff_initial_ms"},
+		{c.Registry.HeartbeatInterval > 0, "registry", "heartbeat_interval", "must be positive"},
+		{c.Registry.HeartbeatTimeout > 0, "registry", "heartbeat_timeout", "must be positive"},
+		{c.Registry.ServerPort > 0 && c.Registry.ServerPort < 65536, "registry", "server_port", "must be a valid port"},
+		{c.Registry.NodeTTL > c.Registry.HeartbeatInterval, "registry", "node_ttl", "must be greater than heartbeat_interval"},
+		{c.Registry.ConnectNodes > 0, "registry", "connect_nodes", "must be positive"},
+
+		{oneOf(c.Bootstrap.Mode, "registry", "static", "dns"), "bootstrap", "mode", `must be "registry", "static" or "dns"`},
+		{c.Bootstrap.Mode != "static" || len(c.Bootstrap.Seeds) > 0, "bootstrap", "seeds", "must contain at least one address in static mode"},
+		{c.Bootstrap.Mode != "dns" || c.Bootstrap.DnsName != "", "bootstrap", "dns_name", "must not be empty in dns mode"},
+		{c.Bootstrap.DnsPort > 0 && c.Bootstrap.DnsPort < 65536, "bootstrap", "dns_port", "must be a valid port"},
+		{c.Bootstrap.ProbeTimeout > 0, "bootstrap", "probe_timeout", "must be positive"},
+
+		{oneOf(c.Results.Format, "csv", "jsonl", "none"), "results", "format", `must be "csv", "jsonl" or "none"`},
+		{c.Results.Format == "none" || c.Results.Path != "", "results", "path", "must not be empty"},
+		{oneOf(c.Results.Naming, "append", "per_run"), "results", "naming", `must be "append" or "per_run"`},
+
+		{!c.State.Enabled || c.State.Path != "", "state", "path", "must not be empty"},
+		{c.State.Interval > 0, "state", "interval", "must be positive"},
+		{c.State.MaxAge > 0, "state", "max_age", "must be positive"},
+	}
+
+	for _, check := range checks {
+		if !check.ok {
+			return fmt.Errorf("[%s] %s %s", check.section, check.key, check.reason)
+		}
+	}
+
+	return nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// reader reads typed keys from an ini file, keeping only the first error encountered
+type reader struct {
+	file *ini.File
+	err  error
+}
+
+func (r *reader) raw(section, key string) (string, bool) {
+	if r.err != nil {
+		return "", false
+	}
+	if !r.file.Section(section).HasKey(key) {
+		r.err = fmt.Errorf("[%s] %s is missing", section, key)
+		return "", false
+	}
+	return strings.TrimSpace(r.file.Section(section).Key(key).String()), true
+}
+
+func (r *reader) string(section, key string) string {
+	s, _ := r.raw(section, key)
+	return s
+}
+
+func (r *reader) int(section, key string) int {
+	s, ok := r.raw(section, key)
+	if !ok {
+		return 0
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		r.err = fmt.Errorf("[%s] %s: %q is not an integer", section, key, s)
+	}
+	return i
+}
+
+func (r *reader) float(section, key string) float64 {
+	s, ok := r.raw(section, key)
+	if !ok {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		r.err = fmt.Errorf("[%s] %s: %q is not a number", section, key, s)
+	}
+	return f
+}
+
+func (r *reader) bool(section, key string) bool {
+	s, ok := r.raw(section, key)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		r.err = fmt.Errorf("[%s] %s: %q is not a boolean", section, key, s)
+	}
+	return b
+}
+
+func (r *reader) seconds(section, key string) time.Duration {
+	return time.Duration(r.int(section, key)) * time.Second
+}
+
+func (r *reader) milliseconds(section, key string) time.Duration {
+	return time.Duration(r.int(section, key)) * time.Millisecond
+}
+
+// list reads a comma separated list, ignoring empty items
+func (r *reader) list(section, key string) []string {
+	s, ok := r.raw(section, key)
+	if !ok {
+		return nil
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// String returns the effective configuration in the same layout of the configuration file
+func (c *HostConfig) String() string {
+	var b strings.Builder
+	write := func(section string, values ...any) {
+		fmt.Fprintf(&b, "[%s]\n", section)
+		for i := 0; i < len(values); i += 2 {
+			fmt.Fprintf(&b, "%s = %v\n", values[i], values[i+1])
+		}
+	}
+
+	write("context",
+		"deadline", int(c.Context.Deadline.Seconds()))
+	write("membership",
+		"view_selection", c.Membership.ViewSelection,
+		"sampling_interval", int(c.Membership.SamplingInterval.Seconds()),
+		"c", c.Membership.C)
+	write("vivaldi",
+		"sampling_interval", int(c.Vivaldi.SamplingInterval.Seconds()),
+		"coordinate_space", c.Vivaldi.CoordinateSpace,
+		"coordinate_dimensions", c.Vivaldi.CoordinateDimensions,
+		"sphere_radius", c.Vivaldi.SphereRadius,
+		"curvature", c.Vivaldi.Curvature,
+		"cc", c.Vivaldi.Cc,
+		"ce", c.Vivaldi.Ce,
+		"gravity_rho", c.Vivaldi.GravityRho,
+		"filter_type", c.Vivaldi.FilterType,
+		"h", c.Vivaldi.H,
+		"p", c.Vivaldi.P,
+		"windowSize", c.Vivaldi.WindowSize,
+		"tau", c.Vivaldi.Tau,
+		"epsilon_r", c.Vivaldi.EpsilonR,
+		"rtt_mode", c.Vivaldi.RttMode,
+		"probe_port", c.Vivaldi.ProbePort,
+		"probe_count", c.Vivaldi.ProbeCount,
+		"probe_aggregate", c.Vivaldi.ProbeAggregate,
+		"probe_timeout_ms", c.Vivaldi.ProbeTimeout.Milliseconds(),
+		"kernel_max_rttvar", c.Vivaldi.KernelMaxRttVar)
+	write("vivaldi_gossip",
+		"sampling_interval", int(c.VivaldiGossip.SamplingInterval.Seconds()),
+		"feedback_counter", c.VivaldiGossip.FeedbackCounter,
+		"feedback_coords_num", c.VivaldiGossip.FeedbackCoordsNum,
+		"retention_seconds", int(c.VivaldiGossip.Retention.Seconds()),
+		"retention_interval", int(c.VivaldiGossip.RetentionInterval.Seconds()))
+	write("registry",
+		"addresses", strings.Join(c.Registry.Addresses, ","),
+		"connect_deadline", int(c.Registry.ConnectDeadline.Seconds()),
+		"backoff_initial_ms", c.Registry.BackoffInitial.Milliseconds(),
+		"backoff_max_ms", c.Registry.BackoffMax.Milliseconds(),
+		"heartbeat_interval", int(c.Registry.HeartbeatInterval.Seconds()),
+		"heartbeat_timeout", int(c.Registry.HeartbeatTimeout.Seconds()),
+		"server_port", c.Registry.ServerPort,
+		"node_ttl", int(c.Registry.NodeTTL.Seconds()),
+		"connect_nodes", c.Registry.ConnectNodes)
+	write("bootstrap",
+		"mode", c.Bootstrap.Mode,
+		"seeds", strings.Join(c.Bootstrap.Seeds, ","),
+		"dns_name", c.Bootstrap.DnsName,
+		"dns_port", c.Bootstrap.DnsPort,
+		"probe_timeout", int(c.Bootstrap.ProbeTimeout.Seconds()))
+	write("results",
+		"format", c.Results.Format,
+		"path", c.Results.Path,
+		"naming", c.Results.Naming)
+	write("state",
+		"enabled", c.State.Enabled,
+		"path", c.State.Path,
+		"interval", int(c.State.Interval.Seconds()),
+		"max_age", int(c.State.MaxAge.Seconds()))
+
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadShipped(t *testing.T) *HostConfig {
+	t.Helper()
+	cfg, err := Load(filepath.Join("..", DefaultPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *HostConfig)
+		err    string // empty when the configuration is valid
+	}{
+		{"shipped", func(c *HostConfig) {}, ""},
+		{"gravity in euclidean space", func(c *HostConfig) {
+			c.Vivaldi.CoordinateSpace, c.Vivaldi.GravityRho = "height_euclidean", 2048
+		}, ""},
+		{"gravity in hyperbolic space", func(c *HostConfig) {
+			c.Vivaldi.CoordinateSpace, c.Vivaldi.GravityRho = "hyperbolic", 2048
+		}, ""},
+		{"no gravity in spherical space", func(c *HostConfig) {
+			c.Vivaldi.CoordinateSpace, c.Vivaldi.GravityRho = "spherical", 0
+		}, ""},
+		{"gravity in spherical space", func(c *HostConfig) {
+			c.Vivaldi.CoordinateSpace, c.Vivaldi.GravityRho = "spherical", 2048
+		}, "gravity_rho"},
+		{"slow probes in grpc mode", func(c *HostConfig) {
+			c.Vivaldi.RttMode, c.Vivaldi.ProbeCount, c.Vivaldi.ProbeTimeout = "grpc", 10, c.Vivaldi.SamplingInterval
+		}, ""},
+		{"slow probes in udp mode", func(c *HostConfig) {
+			c.Vivaldi.RttMode, c.Vivaldi.ProbeCount, c.Vivaldi.ProbeTimeout = "udp", 10, c.Vivaldi.SamplingInterval
+		}, "probe_timeout_ms"},
+		{"no registry in static bootstrap mode", func(c *HostConfig) {
+			c.Bootstrap.Mode, c.Bootstrap.Seeds, c.Registry.Addresses = "static", []string{"127.0.0.1:50152"}, nil
+		}, ""},
+		{"no registry in registry bootstrap mode", func(c *HostConfig) {
+			c.Bootstrap.Mode, c.Registry.Addresses = "registry", nil
+		}, "addresses"},
+		{"negative kernel RTT variation", func(c *HostConfig) {
+			c.Vivaldi.KernelMaxRttVar = -1
+		}, "kernel_max_rttvar"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := loadShipped(t)
+			tc.modify(cfg)
+			err := cfg.Validate()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("valid configuration rejected: %v", err)
+			case tc.err != "" && err == nil:
+				t.Errorf("invalid configuration accepted, want an error about %s", tc.err)
+			case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+				t.Errorf("got error %v, want one about %s", err, tc.err)
+			}
+		})
+	}
+}
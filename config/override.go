@@ -0,0 +1,177 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const EnvPrefix = "SDCC"
+
+// ResultLoggingEnv is the deprecated environment variable enabling the result file, replaced by [results] format
+const ResultLoggingEnv = "RESULT_LOGGING"
+
+type key struct {
+	section string
+	name    string
+}
+
+// keys lists every key of the configuration file that can be overridden
+var keys = []key{
+	{"context", "deadline"},
+
+	{"membership", "view_selection"},
+	{"membership", "sampling_interval"},
+	{"membership", "c"},
+
+	{"vivaldi", "sampling_interval"},
+	{"vivaldi", "coordinate_space"},
+	{"vivaldi", "coordinate_dimensions"},
+	{"vivaldi", "sphere_radius"},
+	{"vivaldi", "curvature"},
+	{"vivaldi", "cc"},
+	{"vivaldi", "ce"},
+	{"vivaldi", "gravity_rho"},
+	{"vivaldi", "filter_type"},
+	{"vivaldi", "h"},
+	{"vivaldi", "p"},
+	{"vivaldi", "windowSize"},
+	{"vivaldi", "tau"},
+	{"vivaldi", "epsilon_r"},
+	{"vivaldi", "rtt_mode"},
+	{"vivaldi", "probe_port"},
+	{"vivaldi", "probe_count"},
+	{"vivaldi", "probe_aggregate"},
+	{"vivaldi", "probe_timeout_ms"},
+	{"vivaldi", "kernel_max_rttvar"},
+
+	{"vivaldi_gossip", "sampling_interval"},
+	{"vivaldi_gossip", "feedback_counter"},
+	{"vivaldi_gossip", "feedback_coords_num"},
+	{"vivaldi_gossip", "retention_seconds"},
+	{"vivaldi_gossip", "retention_interval"},
+
+	{"registry", "addresses"},
+	{"registry", "connect_deadline"},
+	{"registry", "backoff_initial_ms"},
+	{"registry", "backoff_max_ms"},
+	{"registry", "heartbeat_interval"},
+	{"registry", "heartbeat_timeout"},
+	{"registry", "server_port"},
+	{"registry", "node_ttl"},
+	{"registry", "connect_nodes"},
+
+	{"bootstrap", "mode"},
+	{"bootstrap", "seeds"},
+	{"bootstrap", "dns_name"},
+	{"bootstrap", "dns_port"},
+	{"bootstrap", "probe_timeout"},
+	{"results", "format"},
+	{"results", "path"},
+	{"results", "naming"},
+
+	{"state", "enabled"},
+	{"state", "path"},
+	{"state", "interval"},
+	{"state", "max_age"},
+}
+
+// flagName returns the name of the command-line flag overriding the key, e.g. "vivaldi.cc"
+func (k key) flagName() string {
+	return k.section + "." + k.name
+}
+
+// envName returns the name of the environment variable overriding the key, e.g. "SDCC_VIVALDI_CC"
+func (k key) envName() string {
+	return strings.ToUpper(EnvPrefix + "_" + k.section + "_" + k.name)
+}
+
+// Flags contains the command-line flags overriding the keys of the configuration file
+type Flags struct {
+	fs     *flag.FlagSet
+	values map[string]*string
+}
+
+// RegisterFlags defines on fs a string flag for each key of the configuration file
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		fs:     fs,
+		values: make(map[string]*string),
+	}
+	for _, k := range keys {
+		f.values[k.flagName()] = fs.String(k.flagName(), "", fmt.Sprintf("Override [%s] %s (env %s)", k.section, k.name, k.envName()))
+	}
+	return f
+}
+
+// LoadWithOverrides reads the configuration file at path and overrides its keys first with the environment
+// variables and then with the command-line flags that have been explicitly set
+func LoadWithOverrides(path string, flags *Flags) (*HostConfig, error) {
+	file, err := ini.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	applyEnv(file)
+	if flags != nil {
+		flags.apply(file)
+	}
+
+	cfg, err := parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration (file %s, env %s_*, flags): %w", path, EnvPrefix, err)
+	}
+
+	return cfg, nil
+}
+
+func applyEnv(file *ini.File) {
+	applyLegacyEnv(file)
+	for _, k := range keys {
+		if value, ok := os.LookupEnv(k.envName()); ok {
+			file.Section(k.section).Key(k.name).SetValue(value)
+		}
+	}
+}
+
+// applyLegacyEnv maps the deprecated environment variables onto the keys replacing them, which the new variables and
+// the flags still override
+func applyLegacyEnv(file *ini.File) {
+	value, ok := os.LookupEnv(ResultLoggingEnv)
+	if !ok {
+		return
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s is deprecated and %q is not a boolean, ignoring it: use [results] format (env %s)\n",
+			ResultLoggingEnv, value, key{"results", "format"}.envName())
+		return
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s is deprecated: use [results] format (env %s)\n",
+		ResultLoggingEnv, key{"results", "format"}.envName())
+
+	format := file.Section("results").Key("format")
+	switch {
+	case !enabled:
+		format.SetValue("none")
+	case format.String() == "none":
+		format.SetValue("csv")
+	}
+}
+
+func (f *Flags) apply(file *ini.File) {
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	for _, k := range keys {
+		if set[k.flagName()] {
+			file.Section(k.section).Key(k.name).SetValue(*f.values[k.flagName()])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the shipped configuration file with the given result format
+func writeConfig(t *testing.T, format string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", DefaultPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipped := "\n" + `format = "csv"`
+	if !strings.Contains(string(data), shipped) {
+		t.Fatalf("shipped configuration has no %q", shipped)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.ini")
+	data = []byte(strings.Replace(string(data), shipped, "\n"+`format = "`+format+`"`, 1))
+	if err = os.WriteFile(path, data, 0664); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResultLoggingEnvMapsOntoResultsFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		legacy string
+		env    string
+		want   string
+	}{
+		{"disabled", "csv", "false", "", "none"},
+		{"enabled", "none", "true", "", "csv"},
+		{"enabled keeps the format", "jsonl", "1", "", "jsonl"},
+		{"invalid is ignored", "jsonl", "maybe", "", "jsonl"},
+		{"new variable wins", "csv", "false", "jsonl", "jsonl"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(ResultLoggingEnv, tc.legacy)
+			if tc.env != "" {
+				t.Setenv(key{"results", "format"}.envName(), tc.env)
+			}
+
+			cfg, err := LoadWithOverrides(writeConfig(t, tc.file), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Results.Format != tc.want {
+				t.Errorf("format is %q, want %q", cfg.Results.Format, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"github.com/google/uuid"
+	"log/slog"
+	"math"
+	"os"
+	"sdcc_host/admin"
+	"sdcc_host/config"
+	"sdcc_host/metrics"
+	m "sdcc_host/model"
+	"sdcc_host/results"
+	s "sdcc_host/services"
+	"sdcc_host/state"
+	uh "sdcc_host/utils"
+	"sdcc_host/vivaldi"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Options contains everything needed to build a Host
+type Options struct {
+	Config         *config.HostConfig
+	MembershipPort uint // 0 for an ephemeral port
+	VivaldiPort    uint // 0 for an ephemeral port
+	GossipPort     uint // 0 for an ephemeral port
+	MetricsPort    uint // 0 for an ephemeral port
+	AdminPort      uint // 0 for an ephemeral port
+}
+
+// Host is a Vivaldi node running the membership, vivaldi and gossip protocols
+type Host struct {
+	opts               Options
+	space              m.Space
+	id                 string
+	registryConnector  *s.RegistryConnectorClient
+	filter             vivaldi.Filter
+	membershipProtocol *s.MembershipProtocol
+	vivaldiProtocol    *s.VivaldiProtocol
+	vivaldiGossip      *s.VivaldiGossip
+	estimator          *s.Estimator
+	currentServerNode  *pb.Node
+	pView              *m.PartialView
+	metrics            *metrics.Metrics
+	adminServer        *admin.Server
+	resultSink         results.ResultSink
+	restoredView       []*pb.Node // nodes of the partial view saved before the last restart
+	logger             *slog.Logger
+	membershipLogger   *slog.Logger
+	cancel             context.CancelFunc
+	wg                 sync.WaitGroup
+	mu                 sync.Mutex
+	started            bool
+	stopped            bool
+}
+
+// NewHost builds the protocols of a host, which have to be started with Start
+func NewHost(opts Options) (*Host, error) {
+	if opts.Config == nil {
+		return nil, fmt.Errorf("missing host configuration")
+	}
+	if err := opts.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid host configuration: %w", err)
+	}
+
+	space, err := m.NewSpace(opts.Config.Vivaldi)
+	if err != nil {
+		return nil, err
+	}
+
+	// A restored host keeps the id it had before restarting
+	var snapshot *state.Snapshot
+	var errState error
+	if opts.Config.State.Enabled {
+		snapshot, errState = loadState(opts.Config)
+	}
+	var id string
+	if snapshot != nil {
+		id = snapshot.NodeId
+	} else {
+		uniqueId, err := uuid.NewUUID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host id: %w", err)
+		}
+		id = uniqueId.String()
+	}
+
+	// Every subsystem has its own logger, all of them tagged with the id of the host
+	newLogger := func(subsystem string) *slog.Logger {
+		return uh.NewLogger(subsystem).With(uh.NodeId(id))
+	}
+	logger := newLogger(uh.SubsystemHost)
+	membershipLogger := newLogger(uh.SubsystemMembership)
+	logger.Info("host created",
+		slog.String("coordinate_space", opts.Config.Vivaldi.CoordinateSpace),
+		slog.String("filter_type", opts.Config.Vivaldi.FilterType))
+
+	resultSink, err := results.NewResultSink(opts.Config.Results, id)
+	if err != nil {
+		return nil, err
+	}
+
+	mt := metrics.NewMetrics()
+	filter := vivaldi.NewFilter(opts.Config.Vivaldi)
+	vivaldiGossip := s.NewVivaldiGossip(opts.Config, space, newLogger(uh.SubsystemGossip), mt)
+	vivaldiProtocol := s.NewVivaldiProtocol(opts.Config, space, vivaldiGossip, filter, newLogger(uh.SubsystemVivaldi), mt,
+		resultSink)
+	vivaldiGossip.SetVivaldiProtocol(vivaldiProtocol)
+
+	h := &Host{
+		opts:               opts,
+		space:              space,
+		id:                 id,
+		registryConnector:  s.NewRegistryConnectorClient(opts.Config.Registry, logger),
+		filter:             filter,
+		membershipProtocol: s.NewMembershipProtocol(opts.Config.Membership, vivaldiProtocol, membershipLogger, mt),
+		vivaldiProtocol:    vivaldiProtocol,
+		estimator:          s.NewEstimator(id, space, vivaldiProtocol, vivaldiGossip.Store()),
+		vivaldiGossip:      vivaldiGossip,
+		metrics:            mt,
+		resultSink:         resultSink,
+		logger:             logger,
+		membershipLogger:   membershipLogger,
+	}
+	h.adminServer = admin.NewServer(h)
+	h.registerMetrics()
+
+	switch {
+	case snapshot != nil:
+		h.restoreState(snapshot)
+	case errors.Is(errState, os.ErrNotExist):
+		logger.Info("no saved state, starting fresh")
+	case errors.Is(errState, state.ErrStale):
+		logger.Info("saved state is stale, starting fresh", uh.Err(errState))
+	case errState != nil:
+		logger.Warn("failed to restore saved state, starting fresh", uh.Err(errState))
+	}
+
+	return h, nil
+}
+
+// loadState reads the saved state, checking that its coordinates belong to the configured space
+func loadState(cfg *config.HostConfig) (*state.Snapshot, error) {
+	snapshot, err := state.Load(cfg.State.Path, cfg.State.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.CoordinateSpace != cfg.Vivaldi.CoordinateSpace || snapshot.CoordinateDimensions != cfg.Vivaldi.CoordinateDimensions {
+		return nil, fmt.Errorf("saved state has %d %s dimensions instead of %d %s", snapshot.CoordinateDimensions,
+			snapshot.CoordinateSpace, cfg.Vivaldi.CoordinateDimensions, cfg.Vivaldi.CoordinateSpace)
+	}
+	return snapshot, nil
+}
+
+func (h *Host) restoreState(snapshot *state.Snapshot) {
+	h.vivaldiProtocol.Restore(snapshot.Vivaldi)
+	if mpFilter, ok := h.filter.(*vivaldi.MPFilter); ok && snapshot.FilterWindows != nil {
+		mpFilter.RestoreWindows(snapshot.FilterWindows)
+	}
+	h.restoredView = snapshot.View
+
+	h.logger.Info("restored saved state",
+		slog.Time("saved", snapshot.Time),
+		slog.Float64("error", snapshot.Vivaldi.Error),
+		slog.Int("view", len(snapshot.View)))
+}
+
+// saveState snapshots the state of the protocols, once the host has joined the cluster
+func (h *Host) saveState() {
+	snapshot := &state.Snapshot{
+		Time:                 time.Now().In(m.Location),
+		NodeId:               h.id,
+		CoordinateSpace:      h.opts.Config.Vivaldi.CoordinateSpace,
+		CoordinateDimensions: h.opts.Config.Vivaldi.CoordinateDimensions,
+		Vivaldi:              h.vivaldiProtocol.State(),
+		View:                 make([]*pb.Node, 0),
+	}
+	if mpFilter, ok := h.filter.(*vivaldi.MPFilter); ok {
+		snapshot.FilterWindows = mpFilter.Windows()
+	}
+	for _, desc := range h.pView.Descriptors() {
+		snapshot.View = append(snapshot.View, desc.Node)
+	}
+
+	if err := state.Save(h.opts.Config.State.Path, snapshot); err != nil {
+		h.logger.Error("failed to save state", uh.Err(err))
+		return
+	}
+	h.logger.Debug("state saved", slog.String("path", h.opts.Config.State.Path))
+}
+
+// saveStatePeriodically saves the state at every interval, until ctx is done
+func (h *Host) saveStatePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(h.opts.Config.State.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.saveState()
+		}
+	}
+}
+
+// mergeNodes adds the restored nodes to the starting ones, skipping the current host and the duplicates, up to size
+func mergeNodes(startingNodes []*pb.Node, restoredNodes []*pb.Node, currentId string, size int) []*pb.Node {
+	merged := make([]*pb.Node, 0, size)
+	seen := map[string]bool{currentId: true}
+	for _, node := range append(slices.Clone(startingNodes), restoredNodes...) {
+		if len(merged) == size {
+			break
+		}
+		if seen[node.GetId()] {
+			continue
+		}
+		seen[node.GetId()] = true
+		merged = append(merged, node)
+	}
+	return merged
+}
+
+// registerMetrics exports the state of the protocols, read at every scrape
+func (h *Host) registerMetrics() {
+	h.metrics.RegisterGauge("vivaldi", "error", "Local error of the system coordinate.", func() float64 {
+		_, coordErr := h.vivaldiProtocol.Coordinate()
+		return coordErr
+	})
+	h.metrics.RegisterGauge("vivaldi", "coordinate_norm", "Euclidean norm of the system coordinate, height excluded.", func() float64 {
+		coord, _ := h.vivaldiProtocol.Coordinate()
+		sum := 0.0
+		for _, x := range coord.GetPoint() {
+			sum += x * x
+		}
+		return math.Sqrt(sum)
+	})
+	h.metrics.RegisterGauge("vivaldi", "coordinate_height", "Height of the system coordinate, 0 in spaces without height.", func() float64 {
+		coord, _ := h.vivaldiProtocol.Coordinate()
+		return coord.GetHeight()
+	})
+	if h.opts.Config.Vivaldi.CoordinateSpace != "spherical" { // the sphere has no origin
+		h.metrics.RegisterGauge("vivaldi", "origin_distance", "Distance in ms of the system coordinate from the origin of the space.", func() float64 {
+			return h.vivaldiProtocol.DistanceFromOrigin()
+		})
+	}
+	h.metrics.RegisterGauge("registry", "degraded", "1 while the registry is unreachable and the host tries to re-register, 0 otherwise.", func() float64 {
+		if h.registryConnector.State() == s.RegistryDegraded {
+			return 1
+		}
+		return 0
+	})
+	h.metrics.RegisterGauge("gossip", "infected_size", "Coordinates in the infected set.", func() float64 {
+		infected, _ := h.vivaldiGossip.SetSizes()
+		return float64(infected)
+	})
+	h.metrics.RegisterGauge("gossip", "removed_size", "Coordinates in the removed set.", func() float64 {
+		_, removed := h.vivaldiGossip.SetSizes()
+		return float64(removed)
+	})
+	h.metrics.RegisterGauge("gossip", "store_items", "Application coordinates in the store.", func() float64 {
+		return float64(len(h.vivaldiGossip.Store().Items()))
+	})
+}
+
+// registerViewMetrics exports the state of the partial view, which exists only once the host has joined the cluster
+func (h *Host) registerViewMetrics(pView *m.PartialView) {
+	h.metrics.RegisterGauge("membership", "view_size", "Descriptors in the partial view.", func() float64 {
+		return float64(len(pView.DescriptorAges()))
+	})
+	h.metrics.RegisterDistribution("membership", "descriptor_age", "Ages of the descriptors in the partial view.",
+		[]float64{0, 1, 2, 4, 8, 16, 32, 64}, func() []float64 {
+			ages := pView.DescriptorAges()
+			values := make([]float64, 0, len(ages))
+			for _, age := range ages {
+				values = append(values, float64(age))
+			}
+			return values
+		})
+}
+
+// Start starts the protocol servers, joins the cluster through the registry and starts the protocol clients,
+// which run until ctx is done or Stop is called
+func (h *Host) Start(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return fmt.Errorf("host already started")
+	}
+	h.started = true
+
+	// A failed host cannot be started again
+	fail := func(err error) error {
+		if h.cancel != nil {
+			h.cancel()
+			h.registryConnector.Wait()
+		}
+		h.stopServers()
+		h.stopped = true
+		return err
+	}
+
+	// Start Protocols and get address infos
+	membershipServerIp, membershipServerPort, err := h.membershipProtocol.StartServer(h.opts.MembershipPort)
+	if err != nil {
+		return fail(err)
+	}
+	vivaldiServerIp, vivaldiServerPort, err := h.vivaldiProtocol.StartServer(h.opts.VivaldiPort)
+	if err != nil {
+		return fail(err)
+	}
+	gossipServerIp, gossipServerPort, err := h.vivaldiGossip.StartServer(h.opts.GossipPort)
+	if err != nil {
+		return fail(err)
+	}
+	metricsPort, err := h.metrics.StartServer(h.opts.MetricsPort)
+	if err != nil {
+		return fail(err)
+	}
+	h.logger.Info("serving metrics", slog.Any("port", metricsPort))
+
+	// Init current server node
+	h.currentServerNode = &pb.Node{
+		Id:             h.id,
+		MembershipIp:   membershipServerIp,
+		MembershipPort: membershipServerPort,
+		VivaldiIp:      vivaldiServerIp,
+		VivaldiPort:    vivaldiServerPort,
+		GossipIp:       gossipServerIp,
+		GossipPort:     gossipServerPort,
+	}
+
+	ctx, h.cancel = context.WithCancel(ctx)
+
+	// Get the starting nodes from the registry or from the seed nodes
+	var startingNodeList []*pb.Node
+	if h.opts.Config.Bootstrap.Mode == "registry" {
+		startingNodeList, err = h.registryConnector.Connect(ctx, h.currentServerNode)
+	} else {
+		startingNodeList, err = s.NewSeedBootstrapper(h.opts.Config.Bootstrap, h.logger).Bootstrap(ctx, h.currentServerNode)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// The nodes known before restarting complete the starting ones
+	if len(h.restoredView) > 0 {
+		startingNodeList = mergeNodes(startingNodeList, h.restoredView, h.id, h.opts.Config.Membership.C)
+	}
+
+	// Init partial view
+	h.pView = m.NewPartialView(h.opts.Config.Membership, h.currentServerNode, startingNodeList, h.membershipLogger)
+	h.membershipProtocol.SetPartialView(h.pView)
+	h.vivaldiProtocol.SetPartialView(h.pView)
+	h.vivaldiGossip.SetPartialView(h.pView)
+	h.registryConnector.SetPartialView(h.pView)
+	h.registerViewMetrics(h.pView)
+
+	// The admin server inspects the partial view, so it starts once the host has joined the cluster
+	adminPort, err := h.adminServer.StartServer(h.opts.AdminPort)
+	if err != nil {
+		return fail(err)
+	}
+	h.logger.Info("serving admin API", slog.Any("port", adminPort))
+	h.logger.Info("host started", slog.Int("starting_nodes", len(startingNodeList)))
+
+	// Start client protocols
+	clients := []func(context.Context){
+		h.membershipProtocol.StartClient,
+		h.vivaldiProtocol.StartClient,
+		h.vivaldiGossip.StartClient,
+	}
+	if h.opts.Config.State.Enabled {
+		clients = append(clients, h.saveStatePeriodically)
+	}
+	for _, client := range clients {
+		h.wg.Add(1)
+		go func(startClient func(context.Context)) {
+			defer h.wg.Done()
+			startClient(ctx)
+		}(client)
+	}
+
+	return nil
+}
+
+// Stop stops the protocol clients and then gracefully stops the protocol servers
+func (h *Host) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.started || h.stopped {
+		return
+	}
+	h.stopped = true
+
+	// Stop client protocols before servers, so that no new request is sent while shutting down
+	h.cancel()
+	h.wg.Wait()
+	h.registryConnector.Wait()
+	if h.opts.Config.State.Enabled {
+		h.saveState()
+	}
+	h.stopServers()
+}
+
+func (h *Host) stopServers() {
+	h.membershipProtocol.Stop()
+	h.vivaldiProtocol.Stop()
+	h.vivaldiGossip.Stop()
+	h.metrics.Stop()
+	h.adminServer.Stop()
+	if err := h.resultSink.Close(); err != nil {
+		h.logger.Error("failed to close result file", uh.Err(err))
+	}
+}
+
+// Id returns the unique identifier of the host
+func (h *Host) Id() string {
+	return h.id
+}
+
+// Node returns the addresses of the host servers, or nil if the host is not started
+func (h *Host) Node() *pb.Node {
+	return h.currentServerNode
+}
+
+// Coordinate returns the current system coordinate of the host and its error
+func (h *Host) Coordinate() (m.Coordinate, float64) {
+	return h.vivaldiProtocol.Coordinate()
+}
+
+// AppCoordinate returns the current application coordinate of the host and the last time it was gossiped
+func (h *Host) AppCoordinate() (m.Coordinate, time.Time) {
+	return h.vivaldiProtocol.AppCoordinate()
+}
+
+// EstimateRTT predicts the RTT to the node from its application coordinate in the store
+func (h *Host) EstimateRTT(nodeId string) (s.Estimate, error) {
+	return h.estimator.EstimateRTT(nodeId)
+}
+
+// EstimateRTTToCoordinate predicts the RTT to a host with the given application coordinate values
+func (h *Host) EstimateRTTToCoordinate(value []float64) (s.Estimate, error) {
+	return h.estimator.EstimateRTTToCoordinate(value)
+}
+
+// Nearest returns the k nodes closest to the coordinate values, or to the application coordinate if they are empty
+func (h *Host) Nearest(value []float64, k int, maxAge time.Duration) ([]m.RankedCoordinate, error) {
+	return h.estimator.Nearest(value, k, maxAge)
+}
+
+// Within returns the nodes whose predicted RTT from the coordinate values, or from the application coordinate if they
+// are empty, is up to maxRtt
+func (h *Host) Within(value []float64, maxRtt time.Duration) ([]m.RankedCoordinate, error) {
+	return h.estimator.Within(value, maxRtt)
+}
+
+// RegistryState returns whether the host is currently registered to the registry
+func (h *Host) RegistryState() s.RegistryState {
+	return h.registryConnector.State()
+}
+
+// Space returns the coordinate space the host coordinates live in
+func (h *Host) Space() m.Space {
+	return h.space
+}
+
+// PartialView returns the partial view of the host, or nil if the host is not started
+func (h *Host) PartialView() *m.PartialView {
+	return h.pView
+}
+
+// Store returns the store of the application coordinates gossiped by the other hosts
+func (h *Host) Store() m.Store {
+	return h.vivaldiGossip.Store()
+}
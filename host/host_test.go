@@ -0,0 +1,192 @@
+package host
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sdcc_host/admin"
+	"sdcc_host/config"
+	"sdcc_host/registry"
+	uh "sdcc_host/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificates writes in dir/cert a CA and the server and client certificates it signs, where the registry and
+// the registry connector look for them
+func writeCertificates(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(dir, "cert"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writePem := func(name, blockType string, data []byte) {
+		f, err := os.Create(filepath.Join(dir, "cert", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		if err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newKey := func(name string) *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePem(name, "EC PRIVATE KEY", der)
+		return key
+	}
+	sign := func(name string, template, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) {
+		template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePem(name, "CERTIFICATE", der)
+	}
+
+	caKey := newKey("ca-key.pem")
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	sign("ca-cert.pem", ca, ca, caKey, caKey)
+	sign("server-cert.pem", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test registry"},
+		IPAddresses:  []net.IP{net.IPv4zero, net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, newKey("server-key.pem"), caKey)
+	sign("client-cert.pem", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "test host"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, newKey("client-key.pem"), caKey)
+}
+
+// freePort returns a port that was free a moment ago, to restart the registry on the same address
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = lis.Close() }()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func startRegistry(t *testing.T, cfg config.RegistryConfig) *registry.Server {
+	t.Helper()
+	r := registry.NewServer(cfg, uh.NewLogger(uh.SubsystemRegistry))
+	if err := r.Start(cfg.ServerPort); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// exposedState returns the registry state reported by the admin API and the value of the degraded gauge
+func exposedState(t *testing.T, h *Host) (string, string) {
+	t.Helper()
+	reply, err := admin.NewServer(h).GetCoordinate(context.Background(), &admin.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, ok := strings.CutPrefix(line, "sdcc_registry_degraded "); ok {
+			return reply.Registry, value
+		}
+	}
+	t.Fatalf("sdcc_registry_degraded is not exported")
+	return "", ""
+}
+
+func waitForState(t *testing.T, h *Host, registryState string, degraded string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		gotState, gotDegraded := exposedState(t, h)
+		if gotState == registryState && gotDegraded == degraded {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registry state is %s with degraded = %s, want %s with degraded = %s", gotState, gotDegraded,
+				registryState, degraded)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRegistryLossIsExposed(t *testing.T) {
+	cfg, err := config.Load(filepath.Join("..", config.DefaultPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The TLS certificates are read relative to the working directory, and generated not to depend on the validity of
+	// the ones in cert
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeCertificates(t, dir)
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	cfg.Registry.ServerPort = freePort(t)
+	cfg.Registry.Addresses = []string{fmt.Sprintf("0.0.0.0:%d", cfg.Registry.ServerPort)}
+	cfg.Registry.ConnectDeadline = 5 * time.Second
+	cfg.Registry.HeartbeatInterval = 100 * time.Millisecond
+	cfg.Registry.HeartbeatTimeout = time.Second
+	cfg.Registry.BackoffInitial = 100 * time.Millisecond
+	cfg.Registry.BackoffMax = 200 * time.Millisecond
+	cfg.Bootstrap.Mode = "registry"
+	cfg.Results.Format = "none"
+	cfg.State.Enabled = false
+
+	r := startRegistry(t, cfg.Registry)
+	h, err := NewHost(Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, h, "disconnected", "0")
+	if err = h.Start(context.Background()); err != nil {
+		r.Stop()
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	waitForState(t, h, "connected", "0")
+
+	// The host keeps running without the registry, and registers again once it is back
+	r.Stop()
+	waitForState(t, h, "degraded", "1")
+	r = startRegistry(t, cfg.Registry)
+	defer r.Stop()
+	waitForState(t, h, "connected", "0")
+}
@@ -2,67 +2,94 @@ package main
 
 import (
 	"context"
-	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	m "sdcc_host/model"
-	s "sdcc_host/services"
-	"sdcc_host/vivaldi"
+	"os/signal"
+	"sdcc_host/config"
+	"sdcc_host/host"
+	"sdcc_host/registry"
+	uh "sdcc_host/utils"
+	"syscall"
+)
+
+var (
+	configPath     = flag.String("config", config.DefaultPath, "Path of the host configuration file")
+	configFlags    = config.RegisterFlags(flag.CommandLine)
+	mode           = flag.String("mode", "host", "Run as a Vivaldi \"host\" or as a stand-in \"registry\"")
+	membershipPort = flag.Uint("membership_port", 50152, "Membership server port")
+	vivaldiPort    = flag.Uint("vivaldi_port", 50153, "Vivaldi server port")
+	gossipPort     = flag.Uint("gossip_port", 50154, "Gossip server port")
+	metricsPort    = flag.Uint("metrics_port", 9090, "Prometheus metrics port")
+	adminPort      = flag.Uint("admin_port", 50100, "Admin server port")
 )
 
 func main() {
-	initFile()
-	ctx := context.Background()
+	flag.Parse()
+	cfg, err := config.LoadWithOverrides(*configPath, configFlags)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	fmt.Printf("Effective configuration:\n%s\n", cfg)
 
-	// Initialize Protocols
-	rc, uniqueId := s.NewRegistryConnectorClient()
-	filter := vivaldi.NewFilter()
-	membershipProtocol := s.NewMembershipProtocol(filter)
-	vivaldiGossip := s.NewVivaldiGossip(filter)
-	vivaldiProtocol := s.NewVivaldiProtocol(vivaldiGossip, filter)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start Protocols and get address infos
-	membershipServerIp, membershipServerPort := membershipProtocol.StartServer()
-	vivaldiServerIp, vivaldiServerPort := vivaldiProtocol.StartServer()
-	gossipServerip, gossipServerPort := vivaldiGossip.StartServer()
+	switch *mode {
+	case "host":
+		runHost(ctx, cfg)
+	case "registry":
+		runRegistry(ctx, cfg)
+	default:
+		log.Fatalf("Invalid mode %q: must be \"host\" or \"registry\"", *mode)
+	}
+
+	// Restore the default behaviour so that a second signal kills the process while shutting down
+	stop()
+}
 
-	// Init current server node
-	currentServerNode := &pb.Node{
-		Id:             uniqueId,
-		MembershipIp:   membershipServerIp,
-		MembershipPort: membershipServerPort,
-		VivaldiIp:      vivaldiServerIp,
-		VivaldiPort:    vivaldiServerPort,
-		GossipIp:       gossipServerip,
-		GossipPort:     gossipServerPort,
+func runHost(ctx context.Context, cfg *config.HostConfig) {
+	h, err := host.NewHost(host.Options{
+		Config:         cfg,
+		MembershipPort: *membershipPort,
+		VivaldiPort:    *vivaldiPort,
+		GossipPort:     *gossipPort,
+		MetricsPort:    *metricsPort,
+		AdminPort:      *adminPort,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create host: %v", err)
 	}
 
-	// Connect to Registry
-	startingNodeList := rc.Connect(ctx, currentServerNode)
+	if err = h.Start(ctx); err != nil {
+		log.Fatalf("Failed to start host: %v", err)
+	}
 
-	// Init partial view
-	pView := m.NewPartialView(currentServerNode, startingNodeList)
-	membershipProtocol.SetPartialView(pView)
-	vivaldiProtocol.SetPartialView(pView)
-	vivaldiGossip.SetPartialView(pView)
+	// Wait for SIGINT/SIGTERM
+	<-ctx.Done()
+	logger := uh.NewLogger(uh.SubsystemHost).With(uh.NodeId(h.Id()))
+	logger.Info("shutting down")
 
-	// Start client protocols
-	go membershipProtocol.StartClient()
-	go vivaldiProtocol.StartClient()
-	go vivaldiGossip.StartClient()
+	h.Stop()
 
-	select {}
+	logger.Info("host stopped")
 }
 
-func initFile() {
-	// Create/Truncate result file
-	file, err := os.Create("/data/results.csv") // Write the file to /data (mapped to a volume)
-	if err != nil {
-		log.Fatalf("Error opening file: %v", err)
-	}
-	_, err = file.WriteString("Time, Error\n")
-	if err != nil {
-		log.Fatalf("Error writing to file: %v", err)
+func runRegistry(ctx context.Context, cfg *config.HostConfig) {
+	logger := uh.NewLogger(uh.SubsystemRegistry)
+	r := registry.NewServer(cfg.Registry, logger)
+	if err := r.Start(cfg.Registry.ServerPort); err != nil {
+		log.Fatalf("Failed to start registry: %v", err)
 	}
-	_ = file.Close()
+	logger.Info("registry listening", slog.Int("port", cfg.Registry.ServerPort))
+
+	// Expire dead nodes until SIGINT/SIGTERM
+	r.ExpireNodes(ctx)
+	logger.Info("shutting down")
+
+	r.Stop()
+
+	logger.Info("registry stopped")
 }
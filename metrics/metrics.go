@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net"
+	"net/http"
+	uh "sdcc_host/utils"
+	"time"
+)
+
+const namespace = "sdcc"
+
+// Protocols labelling the RTT samples, one for each protocol measuring them
+const (
+	ProtocolMembership = "membership"
+	ProtocolVivaldi    = "vivaldi"
+	ProtocolGossip     = "gossip"
+)
+
+// RPCs and probes whose failures are counted
+const (
+	RpcShuffle = "shuffle"
+	RpcPull    = "pull"
+	RpcGossip  = "gossip"
+	RpcProbe   = "probe"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Metrics contains the metrics of a host. The events are observed by the protocols as they happen, while the state
+// of the host (coordinate, view, gossip sets and store) is read through the gauge functions at scrape time.
+type Metrics struct {
+	registry        *prometheus.Registry
+	rttRaw          *prometheus.HistogramVec
+	rttFiltered     *prometheus.HistogramVec
+	predictionError prometheus.Histogram
+	rpcFailures     *prometheus.CounterVec
+	server          *http.Server
+}
+
+func NewMetrics() *Metrics {
+	registry := prometheus.NewRegistry()
+	rttBuckets := prometheus.ExponentialBuckets(0.25, 2, 14) // from 0.25ms to ~2s
+
+	mt := &Metrics{
+		registry: registry,
+		rttRaw: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rtt_raw_milliseconds",
+			Help:      "RTT measured by each protocol, before filtering.",
+			Buckets:   rttBuckets,
+		}, []string{"protocol"}),
+		rttFiltered: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rtt_filtered_milliseconds",
+			Help:      "RTT measured by each protocol, after filtering.",
+			Buckets:   rttBuckets,
+		}, []string{"protocol"}),
+		predictionError: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "vivaldi",
+			Name:      "prediction_error_ratio",
+			Help:      "Relative error between the RTT predicted by the coordinates and the filtered RTT.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10), // from 1% to ~500%
+		}),
+		rpcFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rpc_failures_total",
+			Help:      "Failed RPCs and probes sent by the protocol clients.",
+		}, []string{"rpc"}),
+	}
+
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		mt.rttRaw,
+		mt.rttFiltered,
+		mt.predictionError,
+		mt.rpcFailures,
+	)
+
+	return mt
+}
+
+// ObserveRtt records an RTT sample measured by the given protocol
+func (mt *Metrics) ObserveRtt(protocol string, raw, filtered time.Duration) {
+	mt.rttRaw.WithLabelValues(protocol).Observe(uh.Milliseconds(raw))
+	mt.rttFiltered.WithLabelValues(protocol).Observe(uh.Milliseconds(filtered))
+}
+
+// ObserveRawRtt records an RTT sample measured by the given protocol which is not filtered, since it cannot be used to
+// update the coordinates
+func (mt *Metrics) ObserveRawRtt(protocol string, raw time.Duration) {
+	mt.rttRaw.WithLabelValues(protocol).Observe(uh.Milliseconds(raw))
+}
+
+// ObservePredictionError records the relative error of the RTT predicted by the coordinates
+func (mt *Metrics) ObservePredictionError(relativeError float64) {
+	mt.predictionError.Observe(relativeError)
+}
+
+// RpcFailed counts a failure of the given RPC
+func (mt *Metrics) RpcFailed(rpc string) {
+	mt.rpcFailures.WithLabelValues(rpc).Inc()
+}
+
+// RegisterGauge exports a gauge whose value is read from f at every scrape: f must be safe to call concurrently
+// with the protocols
+func (mt *Metrics) RegisterGauge(subsystem, name, help string, f func() float64) {
+	mt.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, f))
+}
+
+// RegisterDistribution exports a histogram of the values returned by f at every scrape, e.g. the ages of the partial
+// view descriptors: f must be safe to call concurrently with the protocols
+func (mt *Metrics) RegisterDistribution(subsystem, name, help string, buckets []float64, f func() []float64) {
+	mt.registry.MustRegister(&distribution{
+		desc:    prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, nil),
+		buckets: buckets,
+		values:  f,
+	})
+}
+
+// Handler returns the HTTP handler serving the metrics
+func (mt *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(mt.registry, promhttp.HandlerOpts{Registry: mt.registry})
+}
+
+// StartServer serves the metrics on /metrics at the given port (0 for an ephemeral one) and returns the port
+func (mt *Metrics) StartServer(port uint) (uint32, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mt.Handler())
+	mt.server = &http.Server{Handler: mux, ReadHeaderTimeout: shutdownTimeout}
+
+	go func() {
+		if errS := mt.server.Serve(lis); errS != nil && !errors.Is(errS, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve metrics: %v", errS)
+		}
+	}()
+
+	return uint32(lis.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Stop gracefully stops the metrics server, waiting for the pending scrapes
+func (mt *Metrics) Stop() {
+	if mt.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	_ = mt.server.Shutdown(ctx)
+}
+
+// distribution is a histogram computed from scratch at every scrape
+type distribution struct {
+	desc    *prometheus.Desc
+	buckets []float64
+	values  func() []float64
+}
+
+func (d *distribution) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.desc
+}
+
+func (d *distribution) Collect(ch chan<- prometheus.Metric) {
+	values := d.values()
+	counts := make(map[float64]uint64, len(d.buckets))
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+		for _, bound := range d.buckets {
+			if value <= bound {
+				counts[bound]++
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstHistogram(d.desc, uint64(len(values)), sum, counts)
+}
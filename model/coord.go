@@ -24,6 +24,20 @@ type HeightVectorCoordinate struct {
 	Height float64
 }
 
+// SphericalCoordinate is a point on the sphere of its space or, as a difference of points, a vector of the space
+// embedding the sphere
+type SphericalCoordinate struct {
+	SphericalSpace
+	Point []float64
+}
+
+// HyperbolicCoordinate is a point on the hyperboloid of its space or, as a difference of points, a vector of the space
+// embedding the hyperboloid
+type HyperbolicCoordinate struct {
+	HyperbolicSpace
+	Point []float64
+}
+
 func (c EuclideanCoordinate) GetPoint() []float64 {
 	return c.Point
 }
@@ -69,8 +83,10 @@ func (c HeightVectorCoordinate) GetDimension() int {
 	return len(c.Point)
 }
 func (c HeightVectorCoordinate) Proto(error float64) *pb.VivaldiCoordinate {
+	// Point may share its backing array with the height, so the value is built in a new slice
+	value := make([]float64, 0, len(c.Point)+1)
 	return &pb.VivaldiCoordinate{
-		Value: append(c.Point, c.Height),
+		Value: append(append(value, c.Point...), c.Height),
 		Error: error,
 	}
 }
@@ -94,3 +110,67 @@ func (c HeightVectorCoordinate) GetUnitVector() Coordinate {
 
 	return c.NewCoordinate(append(unitVector, c.Height/sum))
 }
+
+func (c SphericalCoordinate) GetPoint() []float64 {
+	return c.Point
+}
+func (c SphericalCoordinate) GetHeight() float64 {
+	return 0
+}
+func (c SphericalCoordinate) GetDimension() int {
+	return len(c.Point)
+}
+func (c SphericalCoordinate) Proto(error float64) *pb.VivaldiCoordinate {
+	return &pb.VivaldiCoordinate{
+		Value: c.Point,
+		Error: error,
+	}
+}
+func (c SphericalCoordinate) GetUnitVector() Coordinate {
+	sum := norm2(c.Point)
+
+	if sum == 0 {
+		return c.GetRandomUnitVector(c.GetDimension())
+	}
+
+	unitVector := make([]float64, c.GetDimension())
+	for i := 0; i < c.GetDimension(); i++ {
+		unitVector[i] = c.Point[i] / sum
+	}
+
+	return c.vector(unitVector)
+}
+
+func (c HyperbolicCoordinate) GetPoint() []float64 {
+	return c.Point
+}
+func (c HyperbolicCoordinate) GetHeight() float64 {
+	return 0
+}
+func (c HyperbolicCoordinate) GetDimension() int {
+	return len(c.Point)
+}
+func (c HyperbolicCoordinate) Proto(error float64) *pb.VivaldiCoordinate {
+	return &pb.VivaldiCoordinate{
+		Value: c.Point,
+		Error: error,
+	}
+}
+
+// GetUnitVector normalizes the vector with the Minkowski norm, which is the length of the vectors tangent to the
+// hyperboloid. Points of the hyperboloid have no such norm, so they get a random unit vector.
+func (c HyperbolicCoordinate) GetUnitVector() Coordinate {
+	sum := lorentzDot(c.Point, c.Point)
+
+	if sum <= 0 {
+		return c.GetRandomUnitVector(c.GetDimension())
+	}
+	sum = math.Sqrt(sum)
+
+	unitVector := make([]float64, c.GetDimension())
+	for i := 0; i < c.GetDimension(); i++ {
+		unitVector[i] = c.Point[i] / sum
+	}
+
+	return c.vector(unitVector)
+}
@@ -3,6 +3,8 @@ package model
 import (
 	"context"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"google.golang.org/grpc"
+	"time"
 )
 
 // Descriptor struct contains the information of each node in the partial view and the (ip, port) used by the
@@ -14,6 +16,7 @@ type Descriptor struct {
 	membershipNodeInterface    pb.MembershipClient
 	vivaldiNodeInterface       pb.VivaldiClient
 	vivaldiGossipNodeInterface pb.VivaldiGossipClient
+	vivaldiConn                *connTracker // connection of the vivaldi client
 }
 
 // DescriptorList Implement sort.Interface for order them increasingly by age
@@ -52,16 +55,24 @@ func (dl *DescriptorList) RemoveDescriptorFromReceiverNodeId(id string) bool {
 	return false
 }
 
-func (dl *Descriptor) ShufflePeers(request *pb.MembershipRequestMessage) (*pb.MembershipReplyMessage, error) {
-	return dl.membershipNodeInterface.ShufflePeers(context.Background(), request)
+func (dl *Descriptor) ShufflePeers(ctx context.Context, request *pb.MembershipRequestMessage,
+	opts ...grpc.CallOption) (*pb.MembershipReplyMessage, error) {
+	return dl.membershipNodeInterface.ShufflePeers(ctx, request, opts...)
 }
 
-func (dl *Descriptor) PullCoordinates() (*pb.VivaldiCoordinate, error) {
-	return dl.vivaldiNodeInterface.PullCoordinates(context.Background(), &pb.Empty{})
+func (dl *Descriptor) PullCoordinates(ctx context.Context) (*pb.VivaldiCoordinate, error) {
+	return dl.vivaldiNodeInterface.PullCoordinates(ctx, &pb.Empty{})
 }
 
-func (dl *Descriptor) GossipCoordinates(coords *pb.GossipCoordinateList) (*pb.GossipCoordinateList, error) {
-	return dl.vivaldiGossipNodeInterface.Gossip(context.Background(), coords)
+// KernelRtt returns the smoothed RTT and its mean deviation measured by the kernel on the connection of the vivaldi
+// client, which is established by the first coordinate pull
+func (dl *Descriptor) KernelRtt() (time.Duration, time.Duration, error) {
+	return dl.vivaldiConn.rtt()
+}
+
+func (dl *Descriptor) GossipCoordinates(ctx context.Context, coords *pb.GossipCoordinateList,
+	opts ...grpc.CallOption) (*pb.GossipCoordinateList, error) {
+	return dl.vivaldiGossipNodeInterface.Gossip(ctx, coords, opts...)
 }
 
 func (dl *Descriptor) GetReceiverNode() *pb.Node {
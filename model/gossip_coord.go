@@ -42,9 +42,9 @@ func (g GossipCoordinate) DecrementCounter() {
 	g.counter--
 }
 
-func Proto2GossipCoordinate(p *pb.GossipCoordinate, counter int) GossipCoordinate {
+func Proto2GossipCoordinate(space Space, p *pb.GossipCoordinate, counter int) GossipCoordinate {
 	return GossipCoordinate{
-		coord:   InstanceSpace.NewCoordinate(p.Value),
+		coord:   space.NewCoordinate(p.Value),
 		node:    p.Node,
 		age:     p.GetTime().AsTime(),
 		counter: counter,
@@ -53,13 +53,8 @@ func Proto2GossipCoordinate(p *pb.GossipCoordinate, counter int) GossipCoordinat
 }
 
 func GossipCoordinate2Proto(g GossipCoordinate) *pb.GossipCoordinate {
-	value := g.coord.GetPoint()
-	if SpaceType == 2 {
-		value = append(value, g.coord.GetHeight())
-	}
-
 	return &pb.GossipCoordinate{
-		Value: value,
+		Value: g.coord.Proto(0).Value,
 		Node:  g.node,
 		Time:  timestamppb.New(g.Age()),
 	}
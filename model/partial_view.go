@@ -1,19 +1,18 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	cm "github.com/AlessandroFinocchi/sdcc_common/model"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
 	"github.com/AlessandroFinocchi/sdcc_common/utils"
-	u "github.com/AlessandroFinocchi/sdcc_common/utils"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
+	"log/slog"
 	"math/rand"
-	"os"
-	uh "sdcc_host/utils"
+	"sdcc_host/config"
 	"sort"
-	"strconv"
 	"sync"
 )
 
@@ -25,20 +24,15 @@ type PartialView struct {
 	swappers          int
 	mu                *sync.RWMutex
 	r                 *rand.Rand
-	logger            uh.MyLogger
+	logger            *slog.Logger
 }
 
-func NewPartialView(currentServerNode *pb.Node, nodeList []*pb.Node) *PartialView {
+func NewPartialView(cfg config.MembershipConfig, currentServerNode *pb.Node, nodeList []*pb.Node, logger *slog.Logger) *PartialView {
 	var healers, swappers int
 
-	viewSize, err := u.ReadConfigInt("config.ini", "membership", "c")
-	logging, errL := strconv.ParseBool(os.Getenv(LoggingMembershipEnv))
-	if err != nil || errL != nil {
-		log.Fatalf("Failed to read config in partial view")
-	}
+	viewSize := cfg.C
 
-	viewSelection := u.ReadConfigString("config.ini", "membership", "view_selection")
-	switch viewSelection {
+	switch cfg.ViewSelection {
 	case "blind":
 		healers = 0
 		swappers = 0
@@ -49,7 +43,7 @@ func NewPartialView(currentServerNode *pb.Node, nodeList []*pb.Node) *PartialVie
 		healers = 0
 		swappers = viewSize / 2
 	default:
-		fmt.Println("Invalid view selection strategy. Using default values.")
+		logger.Warn("invalid view selection strategy, using default values", slog.String("view_selection", cfg.ViewSelection))
 		healers = 0
 		swappers = 0
 	}
@@ -61,7 +55,8 @@ func NewPartialView(currentServerNode *pb.Node, nodeList []*pb.Node) *PartialVie
 	DescList := make(DescriptorList, 0, viewSize*2)
 	for _, node := range nodeList {
 		membershipNodeInterface, mIp, mPort, errM := getMembershipInterface(cm.ProtoNodeMembershipAddress(node))
-		vivaldiNodeInterface, vIp, vPort, errV := getVivaldiInterface(cm.ProtoNodeVivaldiAddress(node))
+		vivaldiConn := &connTracker{}
+		vivaldiNodeInterface, vIp, vPort, errV := getVivaldiInterface(cm.ProtoNodeVivaldiAddress(node), vivaldiConn)
 		vivaldiGossipNodeInterface, gIp, gPort, errG := getGossipInterface(cm.ProtoNodeGossipAddress(node))
 		if errM != nil || errV != nil || errG != nil {
 			continue
@@ -84,6 +79,7 @@ func NewPartialView(currentServerNode *pb.Node, nodeList []*pb.Node) *PartialVie
 			membershipNodeInterface:    membershipNodeInterface,
 			vivaldiNodeInterface:       vivaldiNodeInterface,
 			vivaldiGossipNodeInterface: vivaldiGossipNodeInterface,
+			vivaldiConn:                vivaldiConn,
 		})
 	}
 	return &PartialView{
@@ -94,7 +90,7 @@ func NewPartialView(currentServerNode *pb.Node, nodeList []*pb.Node) *PartialVie
 		swappers:          swappers,
 		mu:                &sync.RWMutex{},
 		r:                 rand.New(rand.NewSource(42)),
-		logger:            uh.NewMyLogger(logging),
+		logger:            logger,
 	}
 }
 
@@ -114,10 +110,12 @@ func getMembershipInterface(address string) (pb.MembershipClient, string, uint32
 	return membershipNodeInterface, ip, port, nil
 }
 
-func getVivaldiInterface(address string) (pb.VivaldiClient, string, uint32, error) {
+// getVivaldiInterface dials through the tracker, which keeps the connection to read the RTT measured by the kernel
+func getVivaldiInterface(address string, tracker *connTracker) (pb.VivaldiClient, string, uint32, error) {
 	conn, err := grpc.NewClient(
 		address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(tracker.dial))
 	if err != nil {
 		return nil, "", 0, err
 	}
@@ -204,6 +202,34 @@ func (pv *PartialView) GetSendingNodes() []*pb.Node {
 	return sendingNodes
 }
 
+// DescriptorInfo is a copy of the information of a descriptor, safe to read while the partial view changes
+type DescriptorInfo struct {
+	Node *pb.Node
+	Age  int
+}
+
+// Descriptors returns the nodes in the partial view with the ages of their descriptors
+func (pv *PartialView) Descriptors() []DescriptorInfo {
+	pv.mu.RLock()
+	defer pv.mu.RUnlock()
+	descriptors := make([]DescriptorInfo, 0, len(pv.descList))
+	for _, desc := range pv.descList {
+		descriptors = append(descriptors, DescriptorInfo{Node: desc.receiverServerNode, Age: desc.age})
+	}
+	return descriptors
+}
+
+// DescriptorAges returns the ages of the descriptors in the partial view
+func (pv *PartialView) DescriptorAges() []int {
+	pv.mu.RLock()
+	defer pv.mu.RUnlock()
+	ages := make([]int, 0, len(pv.descList))
+	for _, desc := range pv.descList {
+		ages = append(ages, desc.age)
+	}
+	return ages
+}
+
 func (pv *PartialView) RemoveDescriptor(desc *Descriptor) {
 	pv.mu.Lock()
 	defer pv.mu.Unlock()
@@ -230,7 +256,8 @@ func (pv *PartialView) MergeViews(nodes []*pb.Node) {
 		}
 
 		membershipNodeInterface, mIp, mPort, errM := getMembershipInterface(cm.ProtoNodeMembershipAddress(node))
-		vivaldiNodeInterface, vIp, vPort, errV := getVivaldiInterface(cm.ProtoNodeVivaldiAddress(node))
+		vivaldiConn := &connTracker{}
+		vivaldiNodeInterface, vIp, vPort, errV := getVivaldiInterface(cm.ProtoNodeVivaldiAddress(node), vivaldiConn)
 		vivaldiGossipNodeInterface, gIp, gPort, errG := getGossipInterface(cm.ProtoNodeGossipAddress(node))
 		if errM != nil || errV != nil || errG != nil {
 			continue
@@ -253,6 +280,7 @@ func (pv *PartialView) MergeViews(nodes []*pb.Node) {
 			membershipNodeInterface:    membershipNodeInterface,
 			vivaldiNodeInterface:       vivaldiNodeInterface,
 			vivaldiGossipNodeInterface: vivaldiGossipNodeInterface,
+			vivaldiConn:                vivaldiConn,
 		})
 	}
 
@@ -282,14 +310,16 @@ func (pv *PartialView) MergeViews(nodes []*pb.Node) {
 		pv.increaseAge(1 - youngestDesc.age)
 	}
 
-	pv.logger.Log("Merged view")
-	for _, desc := range pv.descList {
-		pv.logger.Log(fmt.Sprintf("%s: %s age: %d",
-			desc.receiverServerNode.Id,
-			cm.ProtoNodeMembershipAddress(desc.receiverServerNode),
-			desc.age))
+	if pv.logger.Enabled(context.Background(), slog.LevelDebug) {
+		view := make([]string, 0, len(pv.descList))
+		for _, desc := range pv.descList {
+			view = append(view, fmt.Sprintf("%s@%s age %d",
+				desc.receiverServerNode.Id,
+				cm.ProtoNodeMembershipAddress(desc.receiverServerNode),
+				desc.age))
+		}
+		pv.logger.Debug("merged view", slog.Any("view", view))
 	}
-	pv.logger.Log("")
 }
 
 func (pv *PartialView) increaseAge(n int) {
@@ -1,12 +1,15 @@
 package model
 
 import (
+	"fmt"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
 	"math"
 	"math/rand"
+	"sdcc_host/config"
 )
 
 type Space interface {
+	VectorSize(dimensions int) int // number of values representing a coordinate with the given dimensions
 	NewCoordinate(point []float64) Coordinate
 	GetRandomUnitVector(dimension int) Coordinate
 	Proto2Coordinate(pc *pb.VivaldiCoordinate) Coordinate
@@ -22,6 +25,41 @@ type EuclideanSpace struct{}
 
 type HeightVectorEuclideanSpace struct{}
 
+// SphericalSpace is the surface of a sphere with the given radius in ms, centered in the origin. The coordinates of a
+// space with n dimensions are points of R^(n+1) on the sphere, and the distance is the length of the great circle arc
+// between them. The differences and their multiples are vectors of R^(n+1) which Add moves along the sphere.
+type SphericalSpace struct {
+	Radius float64
+}
+
+// HyperbolicSpace is the hyperboloid model of the hyperbolic space with the given negative curvature, in 1/ms^2. The
+// coordinates of a space with n dimensions are points of R^(n+1) on the upper sheet of the hyperboloid
+// -x0^2 + x1^2 + ... + xn^2 = 1/curvature, where x0 only depends on the other values, and the distance is the length
+// of the geodesic between them. The differences and their multiples are vectors of R^(n+1) which Add moves along the
+// hyperboloid.
+type HyperbolicSpace struct {
+	Curvature float64
+}
+
+// NewSpace returns the coordinate space chosen in the configuration file
+func NewSpace(cfg config.VivaldiConfig) (Space, error) {
+	switch cfg.CoordinateSpace {
+	case "euclidean":
+		return EuclideanSpace{}, nil
+	case "height_euclidean":
+		return HeightVectorEuclideanSpace{}, nil
+	case "spherical":
+		return SphericalSpace{Radius: cfg.SphereRadius}, nil
+	case "hyperbolic":
+		return HyperbolicSpace{Curvature: cfg.Curvature}, nil
+	default:
+		return nil, fmt.Errorf("unknown coordinate space %q", cfg.CoordinateSpace)
+	}
+}
+
+func (s EuclideanSpace) VectorSize(dimensions int) int {
+	return dimensions
+}
 func (s EuclideanSpace) NewCoordinate(point []float64) Coordinate {
 	return EuclideanCoordinate{
 		Point: point,
@@ -93,6 +131,9 @@ func (s EuclideanSpace) ComputeCentroid(coordList []Coordinate) Coordinate {
 	return centroid
 }
 
+func (s HeightVectorEuclideanSpace) VectorSize(dimensions int) int {
+	return dimensions + 1 // for height
+}
 func (s HeightVectorEuclideanSpace) NewCoordinate(point []float64) Coordinate {
 	return HeightVectorCoordinate{
 		Point:  point[:len(point)-1],
@@ -172,3 +213,325 @@ func (s HeightVectorEuclideanSpace) ComputeCentroid(coordList []Coordinate) Coor
 
 	return s.NewCoordinate(append(centroid.GetPoint(), height))
 }
+
+func (s SphericalSpace) VectorSize(dimensions int) int {
+	return dimensions + 1 // the sphere is embedded in a space with one more dimension
+}
+
+// NewCoordinate projects the point on the sphere, mapping the origin to the first axis
+func (s SphericalSpace) NewCoordinate(point []float64) Coordinate {
+	norm := norm2(point)
+	projected := make([]float64, len(point))
+	if norm == 0 {
+		if len(projected) > 0 {
+			projected[0] = s.Radius
+		}
+	} else {
+		for i := range point {
+			projected[i] = point[i] * s.Radius / norm
+		}
+	}
+
+	return SphericalCoordinate{SphericalSpace: s, Point: projected}
+}
+func (s SphericalSpace) GetRandomUnitVector(dimension int) Coordinate {
+	// Generate a random unit vector, which Add projects on the sphere
+	unitVector := make([]float64, dimension)
+
+	for i := 0; i < dimension; i++ {
+		unitVector[i] = rand.Float64() - 0.5
+	}
+
+	return s.vector(unitVector).GetUnitVector()
+}
+func (s SphericalSpace) Proto2Coordinate(pc *pb.VivaldiCoordinate) Coordinate {
+	return s.NewCoordinate(pc.Value)
+}
+func (s SphericalSpace) CheckDimension(c1 Coordinate, c2 Coordinate) {
+	if c1.GetDimension() != c2.GetDimension() {
+		panic("Coordinates have different dimensions")
+	}
+}
+
+// GetNorm2Distance returns the length of the great circle arc between the coordinates
+func (s SphericalSpace) GetNorm2Distance(c1 Coordinate, c2 Coordinate) float64 {
+	s.CheckDimension(c1, c2)
+
+	return s.Radius * s.angle(c1.GetPoint(), c2.GetPoint())
+}
+
+// Add moves the coordinate c1 along the great circle in the direction of the vector c2, by the length of the vector
+// projected on the plane tangent to the sphere in c1
+func (s SphericalSpace) Add(c1 Coordinate, c2 Coordinate) Coordinate {
+	s.CheckDimension(c1, c2)
+
+	u := unit(c1.GetPoint())
+	t := tangent(u, c2.GetPoint())
+	length := norm2(t)
+	if length == 0 {
+		return s.NewCoordinate(u)
+	}
+
+	theta := length / s.Radius
+	moved := make([]float64, len(u))
+	for i := range u {
+		moved[i] = math.Cos(theta)*u[i] + math.Sin(theta)*t[i]/length
+	}
+
+	return s.NewCoordinate(moved)
+}
+
+// Subtract returns the vector tangent to the sphere in c1 pointing away from c2, long as the distance between them.
+// It is the null vector when the coordinates are equal or antipodal, since every direction is as good.
+func (s SphericalSpace) Subtract(c1 Coordinate, c2 Coordinate) Coordinate {
+	s.CheckDimension(c1, c2)
+
+	u := unit(c1.GetPoint())
+	t := tangent(u, unit(c2.GetPoint()))
+	length := norm2(t)
+	difference := make([]float64, len(u))
+	if length == 0 {
+		return s.vector(difference)
+	}
+
+	distance := s.Radius * s.angle(c1.GetPoint(), c2.GetPoint())
+	for i := range t {
+		difference[i] = -t[i] / length * distance
+	}
+
+	return s.vector(difference)
+}
+func (s SphericalSpace) Multiply(c Coordinate, scalar float64) Coordinate {
+	product := make([]float64, c.GetDimension())
+	for i := 0; i < c.GetDimension(); i++ {
+		product[i] = c.GetPoint()[i] * scalar
+	}
+
+	return s.vector(product)
+}
+
+// ComputeCentroid returns the projection on the sphere of the mean of the coordinates, or the first of them if the
+// mean is the origin
+func (s SphericalSpace) ComputeCentroid(coordList []Coordinate) Coordinate {
+	sum := make([]float64, coordList[0].GetDimension())
+	for _, coord := range coordList {
+		for i, x := range unit(coord.GetPoint()) {
+			sum[i] += x
+		}
+	}
+
+	if norm2(sum) == 0 {
+		return s.NewCoordinate(coordList[0].GetPoint())
+	}
+	return s.NewCoordinate(sum)
+}
+
+// vector returns a coordinate with the given values, without projecting them on the sphere
+func (s SphericalSpace) vector(value []float64) SphericalCoordinate {
+	return SphericalCoordinate{SphericalSpace: s, Point: value}
+}
+
+// angle returns the angle between the vectors, computed from the chord between their unit vectors, which is more
+// precise than the arccosine of their dot product for close vectors
+func (s SphericalSpace) angle(v1 []float64, v2 []float64) float64 {
+	u1, u2 := unit(v1), unit(v2)
+	var diff, sum float64
+	for i := range u1 {
+		diff += (u1[i] - u2[i]) * (u1[i] - u2[i])
+		sum += (u1[i] + u2[i]) * (u1[i] + u2[i])
+	}
+
+	return 2 * math.Atan2(math.Sqrt(diff), math.Sqrt(sum))
+}
+
+func norm2(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	return math.Sqrt(sum)
+}
+
+// unit returns the unit vector of v, or the first axis if v is the origin
+func unit(v []float64) []float64 {
+	u := make([]float64, len(v))
+	norm := norm2(v)
+	if norm == 0 {
+		if len(u) > 0 {
+			u[0] = 1
+		}
+		return u
+	}
+	for i := range v {
+		u[i] = v[i] / norm
+	}
+	return u
+}
+
+// tangent returns the component of v orthogonal to the unit vector u
+func tangent(u []float64, v []float64) []float64 {
+	var dot float64
+	for i := range u {
+		dot += u[i] * v[i]
+	}
+	t := make([]float64, len(u))
+	for i := range u {
+		t[i] = v[i] - dot*u[i]
+	}
+	return t
+}
+
+func (s HyperbolicSpace) VectorSize(dimensions int) int {
+	return dimensions + 1 // the hyperboloid is embedded in a space with one more dimension
+}
+
+// NewCoordinate projects the point on the hyperboloid, replacing its first value with the one of the point of the
+// hyperboloid having the same other values. The origin is mapped to the vertex of the hyperboloid.
+func (s HyperbolicSpace) NewCoordinate(point []float64) Coordinate {
+	projected := make([]float64, len(point))
+	if len(point) == 0 {
+		return HyperbolicCoordinate{HyperbolicSpace: s, Point: projected}
+	}
+
+	copy(projected, point)
+	r := s.radius()
+	projected[0] = math.Sqrt(r*r + lorentzDot(projected, projected) + projected[0]*projected[0])
+
+	return HyperbolicCoordinate{HyperbolicSpace: s, Point: projected}
+}
+func (s HyperbolicSpace) GetRandomUnitVector(dimension int) Coordinate {
+	// Generate a random unit vector orthogonal to the first axis, which Add projects on the hyperboloid
+	unitVector := make([]float64, dimension)
+
+	for i := 1; i < dimension; i++ {
+		unitVector[i] = rand.Float64() - 0.5
+	}
+
+	return s.vector(unitVector).GetUnitVector()
+}
+func (s HyperbolicSpace) Proto2Coordinate(pc *pb.VivaldiCoordinate) Coordinate {
+	return s.NewCoordinate(pc.Value)
+}
+func (s HyperbolicSpace) CheckDimension(c1 Coordinate, c2 Coordinate) {
+	if c1.GetDimension() != c2.GetDimension() {
+		panic("Coordinates have different dimensions")
+	}
+}
+
+// GetNorm2Distance returns the length of the geodesic between the coordinates, computed from the Minkowski norm of
+// their difference, which is more precise than the inverse hyperbolic cosine of their product for close coordinates
+func (s HyperbolicSpace) GetNorm2Distance(c1 Coordinate, c2 Coordinate) float64 {
+	s.CheckDimension(c1, c2)
+
+	p1, p2 := s.NewCoordinate(c1.GetPoint()).GetPoint(), s.NewCoordinate(c2.GetPoint()).GetPoint()
+	difference := make([]float64, len(p1))
+	for i := range p1 {
+		difference[i] = p1[i] - p2[i]
+	}
+	chord := math.Sqrt(math.Max(lorentzDot(difference, difference), 0))
+
+	r := s.radius()
+	return 2 * r * math.Asinh(chord/(2*r))
+}
+
+// Add moves the coordinate c1 along the geodesic in the direction of the vector c2, by the length of the vector
+// projected on the plane tangent to the hyperboloid in c1
+func (s HyperbolicSpace) Add(c1 Coordinate, c2 Coordinate) Coordinate {
+	s.CheckDimension(c1, c2)
+
+	x := s.NewCoordinate(c1.GetPoint()).GetPoint()
+	t := s.tangent(x, c2.GetPoint())
+	length := math.Sqrt(math.Max(lorentzDot(t, t), 0))
+	if length == 0 {
+		return s.NewCoordinate(x)
+	}
+
+	r := s.radius()
+	theta := length / r
+	moved := make([]float64, len(x))
+	for i := range x {
+		moved[i] = math.Cosh(theta)*x[i] + math.Sinh(theta)*r*t[i]/length
+	}
+
+	return s.NewCoordinate(moved)
+}
+
+// Subtract returns the vector tangent to the hyperboloid in c1 pointing away from c2, long as the distance between
+// them. It is the null vector when the coordinates are equal.
+func (s HyperbolicSpace) Subtract(c1 Coordinate, c2 Coordinate) Coordinate {
+	s.CheckDimension(c1, c2)
+
+	x := s.NewCoordinate(c1.GetPoint()).GetPoint()
+	t := s.tangent(x, s.NewCoordinate(c2.GetPoint()).GetPoint())
+	length := math.Sqrt(math.Max(lorentzDot(t, t), 0))
+	difference := make([]float64, len(x))
+	if length == 0 {
+		return s.vector(difference)
+	}
+
+	distance := s.GetNorm2Distance(c1, c2)
+	for i := range t {
+		difference[i] = -t[i] / length * distance
+	}
+
+	return s.vector(difference)
+}
+func (s HyperbolicSpace) Multiply(c Coordinate, scalar float64) Coordinate {
+	product := make([]float64, c.GetDimension())
+	for i := 0; i < c.GetDimension(); i++ {
+		product[i] = c.GetPoint()[i] * scalar
+	}
+
+	return s.vector(product)
+}
+
+// ComputeCentroid returns the projection on the hyperboloid of the sum of the coordinates along the line through the
+// origin, which is always defined since the sum lies inside the upper cone
+func (s HyperbolicSpace) ComputeCentroid(coordList []Coordinate) Coordinate {
+	sum := make([]float64, coordList[0].GetDimension())
+	for _, coord := range coordList {
+		for i, x := range s.NewCoordinate(coord.GetPoint()).GetPoint() {
+			sum[i] += x
+		}
+	}
+
+	scale := s.radius() / math.Sqrt(-lorentzDot(sum, sum))
+	for i := range sum {
+		sum[i] *= scale
+	}
+	return s.NewCoordinate(sum)
+}
+
+// radius returns the radius in ms of the hyperboloid, the distance over which the space stops looking euclidean
+func (s HyperbolicSpace) radius() float64 {
+	return 1 / math.Sqrt(-s.Curvature)
+}
+
+// vector returns a coordinate with the given values, without projecting them on the hyperboloid
+func (s HyperbolicSpace) vector(value []float64) HyperbolicCoordinate {
+	return HyperbolicCoordinate{HyperbolicSpace: s, Point: value}
+}
+
+// tangent returns the component of v orthogonal, with the Minkowski product, to the point x of the hyperboloid
+func (s HyperbolicSpace) tangent(x []float64, v []float64) []float64 {
+	r := s.radius()
+	dot := lorentzDot(x, v)
+	t := make([]float64, len(x))
+	for i := range x {
+		t[i] = v[i] + dot/(r*r)*x[i]
+	}
+	return t
+}
+
+// lorentzDot returns the Minkowski product of the vectors, where the first value has negative sign
+func lorentzDot(v1 []float64, v2 []float64) float64 {
+	var sum float64
+	for i := range v1 {
+		if i == 0 {
+			sum -= v1[i] * v2[i]
+		} else {
+			sum += v1[i] * v2[i]
+		}
+	}
+	return sum
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 1e-9
+
+func TestSphericalSpaceKeepsPointsOnSphere(t *testing.T) {
+	space := SphericalSpace{Radius: 80}
+	tests := []struct {
+		name   string
+		c1, c2 []float64
+	}{
+		{"close points", []float64{1, 0, 0}, []float64{1, 0.01, 0}},
+		{"orthogonal points", []float64{1, 0, 0}, []float64{0, 1, 0}},
+		{"far points", []float64{1, 0, 0}, []float64{-1, 0.1, 0.1}},
+		{"origin", []float64{0, 0, 0}, []float64{3, -2, 5}},
+		{"antipodes", []float64{0, 0, 2}, []float64{0, 0, -7}},
+		{"four dimensions", []float64{0.3, -0.2, 0.9, 0.1}, []float64{-0.5, 0.4, 0.1, 0.7}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c1, c2 := space.NewCoordinate(tc.c1), space.NewCoordinate(tc.c2)
+			checkOnSphere(t, "c1", space, c1)
+			checkOnSphere(t, "c2", space, c2)
+
+			difference := space.Subtract(c1, c2)
+			checkOnSphere(t, "c1 + (c1 - c2)", space, space.Add(c1, difference))
+			checkOnSphere(t, "c1 - (c1 - c2)", space, space.Add(c1, space.Multiply(difference, -1)))
+			checkOnSphere(t, "c2 + 100 c1", space, space.Add(c2, space.Multiply(c1, 100)))
+			checkOnSphere(t, "centroid", space, space.ComputeCentroid([]Coordinate{c1, c2}))
+
+			// Moving c1 toward c2 by their distance reaches c2, unless every direction is as good
+			if norm2(difference.GetPoint()) > 0 {
+				moved := space.Add(c1, space.Multiply(difference, -1))
+				if d := space.GetNorm2Distance(moved, c2); d > 1e-6 {
+					t.Errorf("c1 moved by c2 - c1 is %v away from c2", d)
+				}
+			}
+		})
+	}
+}
+
+func TestSphericalSpaceDistance(t *testing.T) {
+	space := SphericalSpace{Radius: 80}
+	tests := []struct {
+		name     string
+		c1, c2   []float64
+		distance float64
+	}{
+		{"same point", []float64{1, 2, 3}, []float64{1, 2, 3}, 0},
+		{"same direction", []float64{1, 2, 3}, []float64{2, 4, 6}, 0},
+		{"quarter circle", []float64{1, 0, 0}, []float64{0, 1, 0}, math.Pi / 2 * 80},
+		{"antipodes", []float64{1, 0, 0}, []float64{-1, 0, 0}, math.Pi * 80},
+		{"tilted antipodes", []float64{1, -2, 3}, []float64{-1, 2, -3}, math.Pi * 80},
+		{"origin and first axis", []float64{0, 0, 0}, []float64{5, 0, 0}, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c1, c2 := space.NewCoordinate(tc.c1), space.NewCoordinate(tc.c2)
+			d12, d21 := space.GetNorm2Distance(c1, c2), space.GetNorm2Distance(c2, c1)
+			if math.Abs(d12-tc.distance) > tolerance {
+				t.Errorf("distance is %v, want %v", d12, tc.distance)
+			}
+			if d12 != d21 {
+				t.Errorf("distance is not symmetric: %v and %v", d12, d21)
+			}
+			if d := space.GetNorm2Distance(c1, c1); d != 0 {
+				t.Errorf("distance of c1 from itself is %v", d)
+			}
+		})
+	}
+}
+
+func checkOnSphere(t *testing.T, name string, space SphericalSpace, c Coordinate) {
+	t.Helper()
+	if norm := norm2(c.GetPoint()); math.Abs(norm-space.Radius) > tolerance*space.Radius {
+		t.Errorf("%s is %v from the center, want %v", name, norm, space.Radius)
+	}
+}
+
+func TestHyperbolicSpaceKeepsPointsOnHyperboloid(t *testing.T) {
+	space := HyperbolicSpace{Curvature: -0.0004}
+	tests := []struct {
+		name   string
+		c1, c2 []float64
+	}{
+		{"vertex", []float64{0, 0, 0}, []float64{0, 10, -20}},
+		{"close points", []float64{0, 5, 5}, []float64{0, 5.01, 5}},
+		{"far points", []float64{0, 100, 0}, []float64{0, -150, 80}},
+		{"first value ignored", []float64{-30, 20, 10}, []float64{1000, -20, 10}},
+		{"four dimensions", []float64{0, 3, -40, 25}, []float64{0, -60, 10, 5}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c1, c2 := space.NewCoordinate(tc.c1), space.NewCoordinate(tc.c2)
+			checkOnHyperboloid(t, "c1", space, c1)
+			checkOnHyperboloid(t, "c2", space, c2)
+
+			difference := space.Subtract(c1, c2)
+			checkOnHyperboloid(t, "c1 + (c1 - c2)", space, space.Add(c1, difference))
+			checkOnHyperboloid(t, "c1 - (c1 - c2)", space, space.Add(c1, space.Multiply(difference, -1)))
+			checkOnHyperboloid(t, "c2 + 3 (c2 - c1)", space, space.Add(c2, space.Multiply(space.Subtract(c2, c1), 3)))
+			checkOnHyperboloid(t, "centroid", space, space.ComputeCentroid([]Coordinate{c1, c2}))
+
+			// Moving c1 toward c2 by their distance reaches c2
+			moved := space.Add(c1, space.Multiply(difference, -1))
+			if d := space.GetNorm2Distance(moved, c2); d > 1e-6 {
+				t.Errorf("c1 moved by c2 - c1 is %v away from c2", d)
+			}
+		})
+	}
+}
+
+func TestHyperbolicSpaceDistance(t *testing.T) {
+	space := HyperbolicSpace{Curvature: -0.0004}
+	tests := []struct {
+		name   string
+		c1, c2 []float64
+	}{
+		{"same point", []float64{0, 20, 30}, []float64{0, 20, 30}},
+		{"vertex", []float64{0, 0, 0}, []float64{0, 40, 0}},
+		{"close points", []float64{0, 5, 5}, []float64{0, 5.001, 5}},
+		{"far points", []float64{0, 500, 0}, []float64{0, -500, 300}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c1, c2 := space.NewCoordinate(tc.c1), space.NewCoordinate(tc.c2)
+			d12, d21 := space.GetNorm2Distance(c1, c2), space.GetNorm2Distance(c2, c1)
+			if d12 < 0 {
+				t.Errorf("distance is negative: %v", d12)
+			}
+			if math.Abs(d12-d21) > tolerance*math.Max(d12, 1) {
+				t.Errorf("distance is not symmetric: %v and %v", d12, d21)
+			}
+			if d := space.GetNorm2Distance(c1, c1); d != 0 {
+				t.Errorf("distance of c1 from itself is %v", d)
+			}
+
+			// The geodesic distance is the inverse hyperbolic cosine of the Minkowski product
+			r := space.radius()
+			want := r * math.Acosh(math.Max(-lorentzDot(c1.GetPoint(), c2.GetPoint())/(r*r), 1))
+			if math.Abs(d12-want) > 1e-6*math.Max(want, 1) {
+				t.Errorf("distance is %v, want %v", d12, want)
+			}
+		})
+	}
+}
+
+// checkOnHyperboloid checks that the coordinate is on the upper sheet of the hyperboloid, where the Minkowski product
+// of a point with itself is -r^2. The product cancels terms as large as x0^2, so its precision is relative to them.
+func checkOnHyperboloid(t *testing.T, name string, space HyperbolicSpace, c Coordinate) {
+	t.Helper()
+	r, x0 := space.radius(), c.GetPoint()[0]
+	if product := lorentzDot(c.GetPoint(), c.GetPoint()) / (r * r); math.Abs(product+1) > tolerance*x0*x0/(r*r) {
+		t.Errorf("%s has a normalized Minkowski product of %v, want -1", name, product)
+	}
+	if x0 <= 0 {
+		t.Errorf("%s has x0 = %v, not on the upper sheet", name, x0)
+	}
+}
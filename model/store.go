@@ -1,13 +1,13 @@
 package model
 
 import (
-	"fmt"
+	"context"
+	cm "github.com/AlessandroFinocchi/sdcc_common/model"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
-	u "github.com/AlessandroFinocchi/sdcc_common/utils"
+	"log/slog"
 	"math"
-	"os"
+	"sdcc_host/config"
 	uh "sdcc_host/utils"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -21,50 +21,57 @@ type Store interface {
 	UpdateNeighbour(newCoord GossipCoordinate, appCoord GossipCoordinate)
 	GetNeighbourCoords() (Coordinate, bool)
 	GetNeighbourNode() (*pb.Node, bool)
+	Neighbour() (GossipCoordinate, bool)
+	Nearest(coord Coordinate, k int, maxAge time.Duration) []RankedCoordinate
+	Within(coord Coordinate, maxRtt float64) []RankedCoordinate
 	PrintItems()
-	DeleteOutdatedItems()
+	DeleteOutdatedItems(ctx context.Context)
 }
 
-type InMemoryStore struct {
-	mu        *sync.RWMutex
-	coords    map[string]GossipCoordinate
-	neighbour GossipCoordinate
-	logger    uh.MyLogger
+// RankedCoordinate is a stored coordinate with its distance from the coordinate of a query
+type RankedCoordinate struct {
+	GossipCoordinate
+	Distance float64
 }
 
-func NewStore() Store {
-	return NewInMemoryStore()
+type InMemoryStore struct {
+	mu                *sync.RWMutex
+	coords            map[string]GossipCoordinate
+	index             *VPTree // spatial index of coords
+	neighbour         GossipCoordinate
+	space             Space
+	retention         time.Duration
+	retentionInterval time.Duration
+	logger            *slog.Logger
 }
 
-func NewInMemoryStore() *InMemoryStore {
-	coordinateDimensions, err := u.ReadConfigInt("config.ini", "vivaldi", "coordinate_dimensions")
-	logging, errL := strconv.ParseBool(os.Getenv(LoggingGossipEnv))
-	if err != nil || errL != nil {
-		panic("Failed to read config for store")
-	}
+func NewStore(cfg *config.HostConfig, space Space, logger *slog.Logger) Store {
+	return NewInMemoryStore(cfg, space, logger)
+}
 
-	if SpaceType == 2 {
-		coordinateDimensions += 1 // for height
-	}
+func NewInMemoryStore(cfg *config.HostConfig, space Space, logger *slog.Logger) *InMemoryStore {
+	coordinateDimensions := space.VectorSize(cfg.Vivaldi.CoordinateDimensions)
 
 	randomSlice := make([]float64, coordinateDimensions)
 	for i := range randomSlice {
 		randomSlice[i] = math.Inf(1)
 	}
 
-	coord := InstanceSpace.NewCoordinate(randomSlice)
+	coord := space.NewCoordinate(randomSlice)
 
 	neighbour := NewGossipCoordinate(coord, &pb.Node{}, time.Now().In(Location), 0)
 
 	s := &InMemoryStore{
-		mu:        &sync.RWMutex{},
-		coords:    make(map[string]GossipCoordinate),
-		neighbour: neighbour,
-		logger:    uh.NewMyLogger(logging),
+		mu:                &sync.RWMutex{},
+		coords:            make(map[string]GossipCoordinate),
+		index:             NewVPTree(space),
+		neighbour:         neighbour,
+		space:             space,
+		retention:         cfg.VivaldiGossip.Retention,
+		retentionInterval: cfg.VivaldiGossip.RetentionInterval,
+		logger:            logger,
 	}
 
-	go s.DeleteOutdatedItems()
-
 	return s
 }
 
@@ -99,6 +106,7 @@ func (s *InMemoryStore) Remove(nodeId string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.coords, nodeId)
+	s.index.Remove(nodeId)
 }
 func (s *InMemoryStore) Save(coord GossipCoordinate) {
 	s.mu.Lock()
@@ -106,85 +114,115 @@ func (s *InMemoryStore) Save(coord GossipCoordinate) {
 	c, ok := s.coords[coord.node.GetId()]
 	if !ok || c.age.Before(coord.age) {
 		s.coords[coord.node.GetId()] = coord
+		s.index.Insert(coord)
 	}
 }
 func (s *InMemoryStore) UpdateNeighbour(storingCoord GossipCoordinate, appCoord GossipCoordinate) {
+	storingDistance := s.space.GetNorm2Distance(storingCoord.Coord(), appCoord.Coord())
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if InstanceSpace.GetNorm2Distance(s.neighbour.Coord(), appCoord.Coord()) > InstanceSpace.GetNorm2Distance(storingCoord.Coord(), appCoord.Coord()) {
+	// The initial neighbour is never stored, and in bounded spaces it is not farther than every other coordinate
+	_, stored := s.coords[s.neighbour.Node().GetId()]
+	if !stored || s.space.GetNorm2Distance(s.neighbour.Coord(), appCoord.Coord()) > storingDistance {
 		s.neighbour = s.coords[storingCoord.node.GetId()]
-		s.logger.Log(fmt.Sprintf("Neighbour updated: %s:%d:%v\n\n",
-			s.neighbour.Node().GetMembershipIp(),
-			s.neighbour.Node().GetMembershipPort(),
-			s.neighbour.Coord().Proto(1).Value))
-
-		_ = os.Stdout.Sync()
+		s.logger.Debug("neighbour updated",
+			uh.PeerId(s.neighbour.Node().GetId()),
+			slog.String("address", cm.ProtoNodeMembershipAddress(s.neighbour.Node())),
+			slog.Any("coordinate", s.neighbour.Coord().Proto(1).Value))
 	}
 }
 func (s *InMemoryStore) GetNeighbourCoords() (Coordinate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if _, ok := s.coords[s.neighbour.Node().GetId()]; ok {
 		return s.neighbour.Coord(), true
 	}
 	return nil, false
 }
 func (s *InMemoryStore) GetNeighbourNode() (*pb.Node, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if _, ok := s.coords[s.neighbour.Node().GetId()]; ok {
 		return s.neighbour.Node(), true
 	}
 	return nil, false
 }
-
-func (s *InMemoryStore) FindNeighbour(appCoord GossipCoordinate) {
+func (s *InMemoryStore) Neighbour() (GossipCoordinate, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if _, ok := s.coords[s.neighbour.Node().GetId()]; ok {
+		return s.neighbour, true
+	}
+	return GossipCoordinate{}, false
+}
 
-	var minDistance = math.Inf(1)
-	var neighbourId string
+// Nearest returns the k stored coordinates closest to coord, sorted by distance. A k not greater than 0 returns all
+// of them, and a maxAge greater than 0 skips the coordinates chosen longer than maxAge ago.
+func (s *InMemoryStore) Nearest(coord Coordinate, k int, maxAge time.Duration) []RankedCoordinate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if len(s.coords) < 2 {
-		return
+	var accept func(GossipCoordinate) bool
+	if maxAge > 0 {
+		accept = func(item GossipCoordinate) bool {
+			return time.Since(item.age) <= maxAge
+		}
 	}
+	return s.index.Nearest(coord, k, accept)
+}
 
-	for _, v := range s.coords {
-		if v.node.GetId() == appCoord.node.GetId() {
-			continue
-		}
-		distance := InstanceSpace.GetNorm2Distance(v.Coord(), appCoord.Coord())
-		if distance < minDistance {
-			minDistance = distance
-			neighbourId = v.Node().GetId()
-		}
+// Within returns the stored coordinates at a distance from coord up to maxRtt milliseconds, sorted by distance
+func (s *InMemoryStore) Within(coord Coordinate, maxRtt float64) []RankedCoordinate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.Within(coord, maxRtt, nil)
+}
+
+func (s *InMemoryStore) FindNeighbour(appCoord GossipCoordinate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nearest := s.index.Nearest(appCoord.Coord(), 1, func(item GossipCoordinate) bool {
+		return item.node.GetId() != appCoord.node.GetId()
+	})
+	if len(nearest) == 0 {
+		return
 	}
-	s.neighbour = s.coords[neighbourId]
+	s.neighbour = nearest[0].GossipCoordinate
 }
 func (s *InMemoryStore) PrintItems() {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	s.logger.Log(fmt.Sprintf("Stored items at %s:", time.Now().In(Location)))
-	for _, item := range s.Items() {
-		s.logger.Log(fmt.Sprintf("%s: %v %v", item.Node().GetMembershipIp(), item.Coord().Proto(1).Value, item.Age()))
+	if !s.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
 	}
-	s.logger.Log("")
-	_ = os.Stdout.Sync()
-}
-func (s *InMemoryStore) DeleteOutdatedItems() {
-	retentionSeconds, err1 := u.ReadConfigInt("config.ini", "vivaldi_gossip", "retention_seconds")
-	retentionInterval, err2 := u.ReadConfigInt("config.ini", "vivaldi_gossip", "retention_interval")
-	if err1 != nil || err2 != nil {
-		panic("Failed to read config for store")
+	for _, item := range s.coords {
+		s.logger.Debug("stored item",
+			uh.PeerId(item.Node().GetId()),
+			slog.String("address", cm.ProtoNodeMembershipAddress(item.Node())),
+			slog.Any("coordinate", item.Coord().Proto(1).Value),
+			slog.Time("age", item.Age()))
 	}
-
-	ticker := time.NewTicker(time.Duration(retentionInterval) * time.Second)
-	retentionThreshold := time.Duration(retentionSeconds) * time.Second
-	for range ticker.C {
-		s.mu.Lock()
-		for k, v := range s.coords {
-			if time.Since(v.age) > retentionThreshold {
-				delete(s.coords, k)
+}
+func (s *InMemoryStore) DeleteOutdatedItems(ctx context.Context) {
+	ticker := time.NewTicker(s.retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			for k, v := range s.coords {
+				if time.Since(v.age) > s.retention {
+					delete(s.coords, k)
+					s.index.Remove(k)
+				}
 			}
+			s.mu.Unlock()
 		}
-		s.mu.Unlock()
 	}
 }
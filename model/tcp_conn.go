@@ -0,0 +1,48 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoTcpConnection    = errors.New("no TCP connection to the peer")
+	ErrTcpInfoUnsupported = errors.New("TCP_INFO is not supported on this platform")
+)
+
+// connTracker keeps the last TCP connection dialed by a gRPC client, which redials it after a failure
+type connTracker struct {
+	mu   sync.Mutex
+	conn *net.TCPConn
+}
+
+func (t *connTracker) dial(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		t.mu.Lock()
+		t.conn = tcpConn
+		t.mu.Unlock()
+	}
+	return conn, nil
+}
+
+func (t *connTracker) rtt() (time.Duration, time.Duration, error) {
+	if t == nil {
+		return 0, 0, ErrNoTcpConnection
+	}
+
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return 0, 0, ErrNoTcpConnection
+	}
+	return tcpInfoRtt(conn)
+}
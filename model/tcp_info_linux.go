@@ -0,0 +1,33 @@
+//go:build linux
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"net"
+	"time"
+)
+
+// TcpInfoSupported tells whether the RTT measured by the kernel can be read on this platform
+const TcpInfoSupported = true
+
+// tcpInfoRtt returns the tcpi_rtt and tcpi_rttvar of the connection, which the kernel keeps in microseconds
+func tcpInfoRtt(conn *net.TCPConn) (time.Duration, time.Duration, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to access TCP connection: %w", err)
+	}
+
+	var info *unix.TCPInfo
+	var errI error
+	err = rawConn.Control(func(fd uintptr) {
+		info, errI = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err = errors.Join(err, errI); err != nil {
+		return 0, 0, fmt.Errorf("failed to read TCP_INFO: %w", err)
+	}
+
+	return time.Duration(info.Rtt) * time.Microsecond, time.Duration(info.Rttvar) * time.Microsecond, nil
+}
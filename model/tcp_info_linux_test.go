@@ -0,0 +1,59 @@
+//go:build linux
+
+package model
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTrackerReadsKernelRtt(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = lis.Close() }()
+	go func() {
+		conn, errA := lis.Accept()
+		if errA != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	tracker := &connTracker{}
+	if _, _, err = tracker.rtt(); !errors.Is(err, ErrNoTcpConnection) {
+		t.Fatalf("got %v before dialing, want ErrNoTcpConnection", err)
+	}
+
+	conn, err := tracker.dial(context.Background(), lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// A few round trips, so that the kernel samples the RTT of the data besides the one of the handshake
+	buffer := make([]byte, 4)
+	for i := 0; i < 5; i++ {
+		if _, err = conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(conn, buffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rtt, rttVar, err := tracker.rtt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// On the loopback interface the RTT is usually a few tens of microseconds, far below a millisecond
+	if rtt <= 0 || rtt > time.Second || rttVar < 0 {
+		t.Errorf("kernel RTT is %v ± %v", rtt, rttVar)
+	}
+}
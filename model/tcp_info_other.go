@@ -0,0 +1,15 @@
+//go:build !linux
+
+package model
+
+import (
+	"net"
+	"time"
+)
+
+// TcpInfoSupported tells whether the RTT measured by the kernel can be read on this platform
+const TcpInfoSupported = false
+
+func tcpInfoRtt(_ *net.TCPConn) (time.Duration, time.Duration, error) {
+	return 0, 0, ErrTcpInfoUnsupported
+}
@@ -0,0 +1,218 @@
+package model
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// minRebuildChanges is the number of changes after which a small tree is rebuilt
+const minRebuildChanges = 32
+
+// VPTree is a vantage-point tree indexing gossiped coordinates by their distance in the coordinate space. Its pruning
+// only relies on the distance being symmetric and satisfying the triangle inequality, which also holds with heights,
+// since they add up on both sides of it. It is not safe for concurrent use.
+//
+// Insertions descend the tree and removals only mark the node of the coordinate, so the tree is rebuilt balanced once
+// the changes since the last build exceed the coordinates it holds.
+type VPTree struct {
+	space   Space
+	root    *vpNode
+	nodes   map[string]*vpNode // node of the live coordinate of each host
+	changes int                // insertions and removals since the last build
+}
+
+// vpNode holds a vantage point: the coordinates at a distance from it up to radius are in the inside subtree, the
+// farther ones in the outside subtree
+type vpNode struct {
+	item    GossipCoordinate
+	removed bool
+	split   bool // whether radius has been chosen, which happens when the first coordinate is added below the node
+	radius  float64
+	inside  *vpNode
+	outside *vpNode
+}
+
+func NewVPTree(space Space) *VPTree {
+	return &VPTree{
+		space: space,
+		nodes: make(map[string]*vpNode),
+	}
+}
+
+// Len returns the number of coordinates in the tree
+func (t *VPTree) Len() int {
+	return len(t.nodes)
+}
+
+// Insert adds the coordinate to the tree, replacing the previous one of the same host
+func (t *VPTree) Insert(item GossipCoordinate) {
+	t.Remove(item.Node().GetId())
+
+	n := &vpNode{item: item}
+	t.nodes[item.Node().GetId()] = n
+	t.changes++
+	if t.root == nil {
+		t.root = n
+		return
+	}
+
+	current := t.root
+	for {
+		d := t.space.GetNorm2Distance(current.item.Coord(), item.Coord())
+		if !current.split {
+			current.split = true
+			current.radius = d
+		}
+
+		next := &current.outside
+		if d <= current.radius {
+			next = &current.inside
+		}
+		if *next == nil {
+			*next = n
+			break
+		}
+		current = *next
+	}
+
+	t.rebuildIfUnbalanced()
+}
+
+// Remove deletes the coordinate of the host from the tree, if it is there
+func (t *VPTree) Remove(nodeId string) {
+	n, ok := t.nodes[nodeId]
+	if !ok {
+		return
+	}
+
+	n.removed = true
+	delete(t.nodes, nodeId)
+	t.changes++
+	t.rebuildIfUnbalanced()
+}
+
+// Nearest returns the k coordinates closest to coord and accepted by the filter, sorted by distance. A k not greater
+// than 0 returns all the accepted coordinates, and a nil filter accepts all of them.
+func (t *VPTree) Nearest(coord Coordinate, k int, accept func(GossipCoordinate) bool) []RankedCoordinate {
+	s := &vpSearch{coord: coord, k: k, radius: math.Inf(1), accept: accept}
+	t.search(t.root, s)
+	return s.found
+}
+
+// Within returns the coordinates at a distance from coord up to radius and accepted by the filter, sorted by
+// distance. A nil filter accepts all of them.
+func (t *VPTree) Within(coord Coordinate, radius float64, accept func(GossipCoordinate) bool) []RankedCoordinate {
+	s := &vpSearch{coord: coord, radius: radius, accept: accept}
+	t.search(t.root, s)
+	return s.found
+}
+
+// vpSearch collects the results of a query. Only the coordinates at a distance up to radius are collected, and with a
+// k greater than 0 radius shrinks to the distance of the k-th result once it is found.
+type vpSearch struct {
+	coord  Coordinate
+	k      int
+	radius float64
+	accept func(GossipCoordinate) bool
+	found  []RankedCoordinate
+}
+
+func (s *vpSearch) offer(item GossipCoordinate, distance float64) {
+	if distance > s.radius || (s.accept != nil && !s.accept(item)) {
+		return
+	}
+
+	i, _ := slices.BinarySearchFunc(s.found, distance, func(r RankedCoordinate, d float64) int {
+		return cmp.Compare(r.Distance, d)
+	})
+	s.found = slices.Insert(s.found, i, RankedCoordinate{GossipCoordinate: item, Distance: distance})
+
+	if s.k > 0 && len(s.found) >= s.k {
+		s.found = s.found[:s.k]
+		s.radius = s.found[s.k-1].Distance
+	}
+}
+
+func (t *VPTree) search(n *vpNode, s *vpSearch) {
+	if n == nil {
+		return
+	}
+
+	d := t.space.GetNorm2Distance(s.coord, n.item.Coord())
+	if !n.removed {
+		s.offer(n.item, d)
+	}
+	if !n.split {
+		return
+	}
+
+	// By the triangle inequality, the inside coordinates are at least d - radius away from the query and the outside
+	// ones more than radius - d away, so a subtree is skipped when these bounds exceed the search radius. The subtree
+	// on the side of the query is visited first, to shrink the search radius as soon as possible.
+	if d <= n.radius {
+		t.search(n.inside, s)
+		if n.radius-d < s.radius {
+			t.search(n.outside, s)
+		}
+	} else {
+		t.search(n.outside, s)
+		if d-n.radius <= s.radius {
+			t.search(n.inside, s)
+		}
+	}
+}
+
+func (t *VPTree) rebuildIfUnbalanced() {
+	if t.changes <= max(len(t.nodes), minRebuildChanges) {
+		return
+	}
+
+	items := make([]GossipCoordinate, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		items = append(items, n.item)
+	}
+	t.nodes = make(map[string]*vpNode, len(items))
+	t.root = t.build(items)
+	t.changes = 0
+}
+
+// build returns a balanced tree, choosing random vantage points and the median distance from them as radius
+func (t *VPTree) build(items []GossipCoordinate) *vpNode {
+	if len(items) == 0 {
+		return nil
+	}
+
+	i := rand.Intn(len(items))
+	items[0], items[i] = items[i], items[0]
+	n := &vpNode{item: items[0]}
+	t.nodes[n.item.Node().GetId()] = n
+
+	rest := make([]RankedCoordinate, 0, len(items)-1)
+	for _, item := range items[1:] {
+		rest = append(rest, RankedCoordinate{GossipCoordinate: item, Distance: t.space.GetNorm2Distance(n.item.Coord(), item.Coord())})
+	}
+	if len(rest) == 0 {
+		return n
+	}
+	slices.SortFunc(rest, func(a, b RankedCoordinate) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+
+	n.split = true
+	n.radius = rest[len(rest)/2].Distance
+	inside := make([]GossipCoordinate, 0, len(rest))
+	outside := make([]GossipCoordinate, 0, len(rest)/2)
+	for _, r := range rest {
+		if r.Distance <= n.radius {
+			inside = append(inside, r.GossipCoordinate)
+		} else {
+			outside = append(outside, r.GossipCoordinate)
+		}
+	}
+	n.inside = t.build(inside)
+	n.outside = t.build(outside)
+
+	return n
+}
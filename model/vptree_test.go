@@ -0,0 +1,187 @@
+package model
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testSpaces = []struct {
+	name  string
+	space Space
+}{
+	{"euclidean", EuclideanSpace{}},
+	{"height_euclidean", HeightVectorEuclideanSpace{}},
+	{"spherical", SphericalSpace{Radius: 80}},
+	{"hyperbolic", HyperbolicSpace{Curvature: -0.0004}},
+}
+
+// randomItem returns the coordinate of the host with the given id, with values in [0, 200) ms
+func randomItem(r *rand.Rand, space Space, id string) GossipCoordinate {
+	value := make([]float64, space.VectorSize(3))
+	for i := range value {
+		value[i] = r.Float64() * 200
+	}
+	return NewGossipCoordinate(space.NewCoordinate(value), &pb.Node{Id: id}, time.Now(), 0)
+}
+
+// scan returns the coordinates of items at a distance from coord up to radius and accepted by the filter, sorted by
+// distance, checking all of them as the store did before the index
+func scan(space Space, items map[string]GossipCoordinate, coord Coordinate, radius float64,
+	accept func(GossipCoordinate) bool) []RankedCoordinate {
+	found := make([]RankedCoordinate, 0, len(items))
+	for _, item := range items {
+		distance := space.GetNorm2Distance(coord, item.Coord())
+		if distance <= radius && (accept == nil || accept(item)) {
+			found = append(found, RankedCoordinate{GossipCoordinate: item, Distance: distance})
+		}
+	}
+	slices.SortFunc(found, func(a, b RankedCoordinate) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return found
+}
+
+func scanNearest(space Space, items map[string]GossipCoordinate, coord Coordinate, k int,
+	accept func(GossipCoordinate) bool) []RankedCoordinate {
+	found := scan(space, items, coord, math.Inf(1), accept)
+	if k > 0 && len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
+
+func checkRanked(t *testing.T, query string, got []RankedCoordinate, want []RankedCoordinate) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d coordinates, want %d", query, len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Node().GetId() != want[i].Node().GetId() || got[i].Distance != want[i].Distance {
+			t.Fatalf("%s: coordinate %d is %s at %v, want %s at %v", query, i, got[i].Node().GetId(),
+				got[i].Distance, want[i].Node().GetId(), want[i].Distance)
+		}
+	}
+}
+
+// checkQueries compares the results of the tree with the ones of a scan of items, for random query coordinates
+func checkQueries(t *testing.T, r *rand.Rand, space Space, tree *VPTree, items map[string]GossipCoordinate) {
+	t.Helper()
+	if tree.Len() != len(items) {
+		t.Fatalf("tree has %d coordinates, want %d", tree.Len(), len(items))
+	}
+
+	even := func(item GossipCoordinate) bool {
+		var id int
+		_, _ = fmt.Sscanf(item.Node().GetId(), "node-%d", &id)
+		return id%2 == 0
+	}
+	for q := 0; q < 20; q++ {
+		coord := randomItem(r, space, "query").Coord()
+		for _, k := range []int{0, 1, 5, 50} {
+			checkRanked(t, fmt.Sprintf("nearest %d", k), tree.Nearest(coord, k, nil), scanNearest(space, items, coord, k, nil))
+			checkRanked(t, fmt.Sprintf("nearest %d even", k), tree.Nearest(coord, k, even), scanNearest(space, items, coord, k, even))
+		}
+		for _, radius := range []float64{0, 10, 50, 150} {
+			checkRanked(t, fmt.Sprintf("within %v", radius), tree.Within(coord, radius, nil), scan(space, items, coord, radius, nil))
+			checkRanked(t, fmt.Sprintf("within %v even", radius), tree.Within(coord, radius, even), scan(space, items, coord, radius, even))
+		}
+	}
+}
+
+func TestVPTreeMatchesScan(t *testing.T) {
+	for _, tc := range testSpaces {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			tree := NewVPTree(tc.space)
+			items := make(map[string]GossipCoordinate)
+
+			// Inserted one by one, without rebuilds in between
+			for i := 0; i < 300; i++ {
+				item := randomItem(r, tc.space, fmt.Sprintf("node-%d", i))
+				tree.Insert(item)
+				items[item.Node().GetId()] = item
+			}
+			checkQueries(t, r, tc.space, tree, items)
+
+			// Removed and replaced coordinates, which rebuild the tree once the changes exceed its coordinates
+			for i := 0; i < 300; i += 3 {
+				id := fmt.Sprintf("node-%d", i)
+				tree.Remove(id)
+				delete(items, id)
+			}
+			for i := 1; i < 300; i += 3 {
+				item := randomItem(r, tc.space, fmt.Sprintf("node-%d", i))
+				tree.Insert(item)
+				items[item.Node().GetId()] = item
+			}
+			tree.Remove("unknown")
+			if tree.changes >= 300+100+2*100 { // a replacement is a removal and an insertion
+				t.Fatalf("tree has not been rebuilt after %d changes", tree.changes)
+			}
+			checkQueries(t, r, tc.space, tree, items)
+
+			// Emptied and filled again
+			for id := range items {
+				tree.Remove(id)
+				delete(items, id)
+			}
+			checkQueries(t, r, tc.space, tree, items)
+			for i := 0; i < 50; i++ {
+				item := randomItem(r, tc.space, fmt.Sprintf("node-%d", i))
+				tree.Insert(item)
+				items[item.Node().GetId()] = item
+			}
+			checkQueries(t, r, tc.space, tree, items)
+		})
+	}
+}
+
+var benchmarkSizes = []int{100, 1000, 10000}
+
+func benchmarkItems(space Space, size int) (*VPTree, map[string]GossipCoordinate, []Coordinate) {
+	r := rand.New(rand.NewSource(1))
+	tree := NewVPTree(space)
+	items := make(map[string]GossipCoordinate, size)
+	for i := 0; i < size; i++ {
+		item := randomItem(r, space, fmt.Sprintf("node-%d", i))
+		tree.Insert(item)
+		items[item.Node().GetId()] = item
+	}
+
+	queries := make([]Coordinate, 100)
+	for i := range queries {
+		queries[i] = randomItem(r, space, "query").Coord()
+	}
+	return tree, items, queries
+}
+
+func BenchmarkVPTreeNearest(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			tree, _, queries := benchmarkItems(HeightVectorEuclideanSpace{}, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Nearest(queries[i%len(queries)], 5, nil)
+			}
+		})
+	}
+}
+
+func BenchmarkMapScanNearest(b *testing.B) {
+	space := HeightVectorEuclideanSpace{}
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			_, items, queries := benchmarkItems(space, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				scanNearest(space, items, queries[i%len(queries)], 5, nil)
+			}
+		})
+	}
+}
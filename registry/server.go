@@ -0,0 +1,175 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	u "github.com/AlessandroFinocchi/sdcc_common/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sdcc_host/config"
+	uh "sdcc_host/utils"
+	"sync"
+	"time"
+)
+
+// Server is a lightweight registry: it tracks the heartbeats of the hosts, forgets the ones that stop beating and
+// returns a random subset of the live hosts to each connecting host
+type Server struct {
+	pb.UnimplementedConnectorServer
+	pb.UnimplementedHeartbeatServer
+	nodes        map[string]*registeredNode
+	nodeTTL      time.Duration
+	connectNodes int
+	server       *grpc.Server
+	mu           *sync.RWMutex
+	logger       *slog.Logger
+}
+
+type registeredNode struct {
+	node     *pb.Node
+	lastBeat time.Time
+}
+
+func NewServer(cfg config.RegistryConfig, logger *slog.Logger) *Server {
+	return &Server{
+		nodes:        make(map[string]*registeredNode),
+		nodeTTL:      cfg.NodeTTL,
+		connectNodes: cfg.ConnectNodes,
+		mu:           &sync.RWMutex{},
+		logger:       logger,
+	}
+}
+
+func (s *Server) Connect(ctx context.Context, node *pb.Node) (*pb.NodeList, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+	if node.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing node id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Sample the live nodes, except the connecting one
+	nodes := make([]*pb.Node, 0, len(s.nodes))
+	for id, n := range s.nodes {
+		if id != node.GetId() {
+			nodes = append(nodes, n.node)
+		}
+	}
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	nodes = nodes[:min(len(nodes), s.connectNodes)]
+
+	s.nodes[node.GetId()] = &registeredNode{node: node, lastBeat: time.Now()}
+	s.logger.Info("node connected", uh.PeerId(node.GetId()), slog.Int("nodes", len(nodes)))
+
+	return &pb.NodeList{Nodes: nodes}, nil
+}
+
+func (s *Server) Disconnect(ctx context.Context, node *pb.Node) (*pb.Empty, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, node.GetId())
+	s.logger.Info("node disconnected", uh.PeerId(node.GetId()))
+
+	return &pb.Empty{}, nil
+}
+
+// Beat refreshes the node, registering it again if it has been forgotten (e.g. after a restart of the registry)
+func (s *Server) Beat(ctx context.Context, node *pb.Node) (*pb.Empty, error) {
+	if err := u.ContextError(ctx); err != nil {
+		return nil, err
+	}
+	if node.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing node id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.nodes[node.GetId()]; ok {
+		n.lastBeat = time.Now()
+		n.node = node
+	} else {
+		s.nodes[node.GetId()] = &registeredNode{node: node, lastBeat: time.Now()}
+		s.logger.Info("node registered again by heartbeat", uh.PeerId(node.GetId()))
+	}
+
+	return &pb.Empty{}, nil
+}
+
+// Nodes returns the nodes currently registered
+func (s *Server) Nodes() []*pb.Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	nodes := make([]*pb.Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n.node)
+	}
+	return nodes
+}
+
+// Start serves the connector and heartbeat services with mTLS on the given port
+func (s *Server) Start(port int) error {
+	tlsCredentials, err := uh.LoadServerTLSCredentials()
+	if err != nil {
+		return fmt.Errorf("cannot load TLS credentials: %w", err)
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to create registry listener: %w", err)
+	}
+
+	s.server = grpc.NewServer(grpc.Creds(tlsCredentials))
+	pb.RegisterConnectorServer(s.server, s)
+	pb.RegisterHeartbeatServer(s.server, s)
+
+	go func() {
+		if errS := s.server.Serve(lis); errS != nil {
+			log.Fatalf("Failed to serve: %v", errS)
+		}
+	}()
+
+	return nil
+}
+
+// ExpireNodes periodically forgets the nodes that did not beat within the node TTL, until ctx is done
+func (s *Server) ExpireNodes(ctx context.Context) {
+	ticker := time.NewTicker(s.nodeTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			for id, n := range s.nodes {
+				if time.Since(n.lastBeat) > s.nodeTTL {
+					delete(s.nodes, id)
+					s.logger.Info("node expired", uh.PeerId(id))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Stop gracefully stops the registry server, waiting for the pending requests
+func (s *Server) Stop() {
+	if s.server != nil {
+		s.server.GracefulStop()
+	}
+}
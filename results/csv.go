@@ -0,0 +1,109 @@
+package results
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"os"
+	uh "sdcc_host/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var csvHeader = []string{"time", "event", "node_id", "peer_id", "raw_rtt_ms", "filtered_rtt_ms", "predicted_ms",
+	"error", "coordinate", "reason"}
+
+// CsvSink writes a row for each event, with the coordinate values separated by spaces in a single column
+type CsvSink struct {
+	file   *os.File
+	buffer *bufio.Writer
+	writer *csv.Writer
+	nodeId string
+	mu     *sync.Mutex
+}
+
+// newCsvSink writes the header only in empty files, so that appending runs to the same file keeps it valid
+func newCsvSink(file *os.File, nodeId string, empty bool) (*CsvSink, error) {
+	buffer := bufio.NewWriter(file)
+	s := &CsvSink{
+		file:   file,
+		buffer: buffer,
+		writer: csv.NewWriter(buffer),
+		nodeId: nodeId,
+		mu:     &sync.Mutex{},
+	}
+
+	if empty {
+		if err := s.writer.Write(csvHeader); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *CsvSink) WriteSample(sample Sample) error {
+	return s.write([]string{
+		sample.Time.Format(time.RFC3339Nano),
+		EventSample,
+		s.nodeId,
+		sample.PeerId,
+		formatFloat(uh.Milliseconds(sample.RawRtt)),
+		formatFloat(uh.Milliseconds(sample.FilteredRtt)),
+		formatFloat(sample.Predicted),
+		formatFloat(sample.Error),
+		formatVector(sample.Coordinate),
+		"",
+	})
+}
+
+func (s *CsvSink) WriteAppUpdate(update AppUpdate) error {
+	return s.write([]string{
+		update.Time.Format(time.RFC3339Nano),
+		EventAppUpdate,
+		s.nodeId,
+		"", "", "", "", "",
+		formatVector(update.Coordinate),
+		update.Reason,
+	})
+}
+
+func (s *CsvSink) write(row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writer.Write(row); err != nil {
+		return err
+	}
+	// Every row reaches the file as soon as it is written, not to lose the buffered ones if the host is killed
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	return s.buffer.Flush()
+}
+
+func (s *CsvSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writer.Flush()
+	errW := s.writer.Error()
+	errF := s.buffer.Flush()
+	errS := s.file.Sync()
+	errC := s.file.Close()
+	return errors.Join(errW, errF, errS, errC)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatVector(v []float64) string {
+	values := make([]string, 0, len(v))
+	for _, f := range v {
+		values = append(values, formatFloat(f))
+	}
+	return strings.Join(values, " ")
+}
@@ -0,0 +1,88 @@
+package results
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	uh "sdcc_host/utils"
+	"sync"
+	"time"
+)
+
+// JsonlSink writes a JSON object for each event, one per line
+type JsonlSink struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	nodeId  string
+	mu      *sync.Mutex
+}
+
+type jsonlRow struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	NodeId        string    `json:"node_id"`
+	PeerId        string    `json:"peer_id,omitempty"`
+	RawRttMs      *float64  `json:"raw_rtt_ms,omitempty"`
+	FilteredRttMs *float64  `json:"filtered_rtt_ms,omitempty"`
+	PredictedMs   *float64  `json:"predicted_ms,omitempty"`
+	Error         *float64  `json:"error,omitempty"`
+	Coordinate    []float64 `json:"coordinate"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+func newJsonlSink(file *os.File, nodeId string) *JsonlSink {
+	buffer := bufio.NewWriter(file)
+	return &JsonlSink{
+		file:    file,
+		buffer:  buffer,
+		encoder: json.NewEncoder(buffer),
+		nodeId:  nodeId,
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (s *JsonlSink) WriteSample(sample Sample) error {
+	rawRtt, filteredRtt := uh.Milliseconds(sample.RawRtt), uh.Milliseconds(sample.FilteredRtt)
+	return s.write(jsonlRow{
+		Time:          sample.Time,
+		Event:         EventSample,
+		NodeId:        s.nodeId,
+		PeerId:        sample.PeerId,
+		RawRttMs:      &rawRtt,
+		FilteredRttMs: &filteredRtt,
+		PredictedMs:   &sample.Predicted,
+		Error:         &sample.Error,
+		Coordinate:    sample.Coordinate,
+	})
+}
+
+func (s *JsonlSink) WriteAppUpdate(update AppUpdate) error {
+	return s.write(jsonlRow{
+		Time:       update.Time,
+		Event:      EventAppUpdate,
+		NodeId:     s.nodeId,
+		Coordinate: update.Coordinate,
+		Reason:     update.Reason,
+	})
+}
+
+func (s *JsonlSink) write(row jsonlRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.encoder.Encode(row); err != nil {
+		return err
+	}
+	// Every row reaches the file as soon as it is written, not to lose the buffered ones if the host is killed
+	return s.buffer.Flush()
+}
+
+func (s *JsonlSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errF := s.buffer.Flush()
+	errS := s.file.Sync()
+	errC := s.file.Close()
+	return errors.Join(errF, errS, errC)
+}
@@ -0,0 +1,93 @@
+package results
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sdcc_host/config"
+	"strings"
+	"time"
+)
+
+// Events recorded in the result files
+const (
+	EventSample    = "sample"     // a vivaldi sample updated the system coordinate
+	EventAppUpdate = "app_update" // the stabilizer updated the application coordinate
+)
+
+// Sample is a vivaldi sample, with the system coordinate and error resulting from it
+type Sample struct {
+	Time        time.Time
+	PeerId      string
+	RawRtt      time.Duration
+	FilteredRtt time.Duration
+	Predicted   float64 // distance in ms between the coordinates, before the update
+	Coordinate  []float64
+	Error       float64
+}
+
+// AppUpdate is an update of the application coordinate by the stabilizer
+type AppUpdate struct {
+	Time       time.Time
+	Coordinate []float64
+	Reason     string // heuristic triggering the update: "energy", "relative" or both
+}
+
+// ResultSink records the results of an experiment. Its methods are safe to call concurrently.
+type ResultSink interface {
+	WriteSample(sample Sample) error
+	WriteAppUpdate(update AppUpdate) error
+	Close() error // syncs the results to disk and closes the sink
+}
+
+// NewResultSink returns the sink of the configured format, writing the rows of the given node
+func NewResultSink(cfg config.ResultsConfig, nodeId string) (ResultSink, error) {
+	if cfg.Format == "none" {
+		return nopSink{}, nil
+	}
+
+	path := cfg.Path
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if cfg.Naming == "per_run" {
+		// A run never overwrites the file of another one, failing instead if the name is taken
+		path = perRunPath(path, nodeId, time.Now())
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return nil, fmt.Errorf("failed to create result directory: %w", err)
+	}
+	file, err := os.OpenFile(path, flags, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read result file: %w", err)
+	}
+	empty := info.Size() == 0
+
+	switch cfg.Format {
+	case "csv":
+		return newCsvSink(file, nodeId, empty)
+	case "jsonl":
+		return newJsonlSink(file, nodeId), nil
+	default:
+		_ = file.Close()
+		return nil, fmt.Errorf("invalid result format %q", cfg.Format)
+	}
+}
+
+// perRunPath adds the node id and the start time of the run to the file name, before the extension, so that the hosts
+// sharing a directory and starting in the same second write different files
+func perRunPath(path string, nodeId string, start time.Time) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s-%s%s", strings.TrimSuffix(path, ext), nodeId, start.Format("20060102T150405.000"), ext)
+}
+
+type nopSink struct{}
+
+func (nopSink) WriteSample(Sample) error       { return nil }
+func (nopSink) WriteAppUpdate(AppUpdate) error { return nil }
+func (nopSink) Close() error                   { return nil }
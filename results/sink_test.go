@@ -0,0 +1,66 @@
+package results
+
+import (
+	"os"
+	"path/filepath"
+	"sdcc_host/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSinksWriteRowsBeforeClose(t *testing.T) {
+	for _, format := range []string{"csv", "jsonl"} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "results."+format)
+			sink, err := NewResultSink(config.ResultsConfig{Format: format, Path: path, Naming: "append"}, "node-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = sink.Close() }()
+
+			if err = sink.WriteSample(Sample{Time: time.Now(), PeerId: "peer-1", RawRtt: time.Millisecond}); err != nil {
+				t.Fatal(err)
+			}
+			if err = sink.WriteAppUpdate(AppUpdate{Time: time.Now(), Reason: "energy"}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The rows must be in the file even if the host is killed before closing the sink
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "peer-1") || !strings.Contains(string(data), "energy") {
+				t.Errorf("rows are not in the file before closing the sink:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestPerRunSinksDoNotOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.ResultsConfig{Format: "csv", Path: filepath.Join(dir, "results.csv"), Naming: "per_run"}
+
+	// Hosts sharing the directory and starting at the same time
+	for _, nodeId := range []string{"node-1", "node-2", "node-3"} {
+		sink, err := NewResultSink(cfg, nodeId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sink.WriteSample(Sample{Time: time.Now(), PeerId: "peer"}); err != nil {
+			t.Fatal(err)
+		}
+		if err = sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "results-node-*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got files %v, want one for each node", files)
+	}
+}
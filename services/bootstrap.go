@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log/slog"
+	"net"
+	"sdcc_host/config"
+	uh "sdcc_host/utils"
+	"strconv"
+)
+
+// SeedBootstrapper gets the starting nodes of the partial view without the registry, by shuffling peers with a set of
+// seed nodes: each seed replies with itself and a sample of its own partial view, and adds the current node to it.
+type SeedBootstrapper struct {
+	cfg    config.BootstrapConfig
+	logger *slog.Logger
+}
+
+func NewSeedBootstrapper(cfg config.BootstrapConfig, logger *slog.Logger) *SeedBootstrapper {
+	return &SeedBootstrapper{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// Bootstrap probes every seed node and returns the nodes they replied with. Unreachable seeds are skipped, so the
+// first node of a cluster bootstraps with an empty list.
+func (b *SeedBootstrapper) Bootstrap(ctx context.Context, currentServerNode *pb.Node) ([]*pb.Node, error) {
+	seeds, err := b.seedAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*pb.Node, 0)
+	seen := map[string]bool{currentServerNode.GetId(): true}
+	for _, seed := range seeds {
+		reply, errP := b.probe(ctx, seed, currentServerNode)
+		if errP != nil {
+			b.logger.Warn("seed is unreachable", slog.String("seed", seed), uh.Err(errP))
+			continue
+		}
+		for _, node := range reply {
+			if !seen[node.GetId()] {
+				seen[node.GetId()] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	b.logger.Info("bootstrapped from seeds", slog.Int("seeds", len(seeds)), slog.Int("nodes", len(nodes)))
+	return nodes, nil
+}
+
+func (b *SeedBootstrapper) seedAddresses(ctx context.Context) ([]string, error) {
+	switch b.cfg.Mode {
+	case "static":
+		return b.cfg.Seeds, nil
+	case "dns":
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", b.cfg.DnsName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve seeds %s: %w", b.cfg.DnsName, err)
+		}
+		seeds := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			seeds = append(seeds, net.JoinHostPort(ip.String(), strconv.Itoa(b.cfg.DnsPort)))
+		}
+		return seeds, nil
+	default:
+		return nil, fmt.Errorf("bootstrap mode %q does not use seeds", b.cfg.Mode)
+	}
+}
+
+func (b *SeedBootstrapper) probe(ctx context.Context, address string, currentServerNode *pb.Node) ([]*pb.Node, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctxT, cancel := context.WithTimeout(ctx, b.cfg.ProbeTimeout)
+	defer cancel()
+	reply, err := pb.NewMembershipClient(conn).ShufflePeers(ctxT, &pb.MembershipRequestMessage{
+		Nodes:  []*pb.Node{currentServerNode},
+		Source: currentServerNode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.GetNodes(), nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	m "sdcc_host/model"
+	uh "sdcc_host/utils"
+	"slices"
+	"time"
+)
+
+var (
+	ErrUnknownNode       = errors.New("no coordinate stored for the node")
+	ErrInvalidCoordinate = errors.New("invalid coordinate")
+)
+
+// Estimate is an RTT predicted by the distance between the application coordinate of the current host and a remote
+// coordinate
+type Estimate struct {
+	NodeId     string        // node of the remote coordinate, empty when estimating the RTT to a coordinate
+	Rtt        time.Duration // predicted RTT
+	Time       time.Time     // time the remote coordinate was chosen by its node, zero when estimating to a coordinate
+	Age        time.Duration // age of the remote coordinate when the estimate was computed
+	ErrorBound time.Duration // expected error of the prediction, from the local error of the current host
+}
+
+// Estimator predicts the RTT to the nodes whose application coordinates have been gossiped to the store
+type Estimator struct {
+	nodeId          string
+	space           m.Space
+	vivaldiProtocol *VivaldiProtocol
+	store           m.Store
+}
+
+func NewEstimator(nodeId string, space m.Space, vivaldiProtocol *VivaldiProtocol, store m.Store) *Estimator {
+	return &Estimator{
+		nodeId:          nodeId,
+		space:           space,
+		vivaldiProtocol: vivaldiProtocol,
+		store:           store,
+	}
+}
+
+// EstimateRTT predicts the RTT to the node, returning ErrUnknownNode if its coordinate is not in the store
+func (e *Estimator) EstimateRTT(nodeId string) (Estimate, error) {
+	item, ok := e.store.Read(nodeId)
+	if !ok {
+		return Estimate{}, fmt.Errorf("%w: %s", ErrUnknownNode, nodeId)
+	}
+
+	estimate := e.estimate(item.Coord())
+	estimate.NodeId = nodeId
+	estimate.Time = item.Age()
+	estimate.Age = time.Since(item.Age())
+	return estimate, nil
+}
+
+// EstimateRTTToCoordinate predicts the RTT to a host with the given coordinate values, which have the wire format of
+// the coordinate space
+func (e *Estimator) EstimateRTTToCoordinate(value []float64) (Estimate, error) {
+	remote, err := e.coordinate(value)
+	if err != nil {
+		return Estimate{}, err
+	}
+	return e.estimate(remote), nil
+}
+
+// Nearest returns the k nodes of the store closest to the given coordinate values, or to the application coordinate
+// of the current host when they are empty, skipping the coordinates older than maxAge if it is greater than 0
+func (e *Estimator) Nearest(value []float64, k int, maxAge time.Duration) ([]m.RankedCoordinate, error) {
+	if len(value) > 0 {
+		coord, err := e.coordinate(value)
+		if err != nil {
+			return nil, err
+		}
+		return e.store.Nearest(coord, k, maxAge), nil
+	}
+
+	// The store also holds the coordinate gossiped by the current host, which is not a neighbour of itself
+	appCoord, _ := e.vivaldiProtocol.AppCoordinate()
+	n := k
+	if k > 0 {
+		n = k + 1
+	}
+	nearest := slices.DeleteFunc(e.store.Nearest(appCoord, n, maxAge), func(item m.RankedCoordinate) bool {
+		return item.Node().GetId() == e.nodeId
+	})
+	if k > 0 && len(nearest) > k {
+		nearest = nearest[:k]
+	}
+	return nearest, nil
+}
+
+// Within returns the nodes of the store whose predicted RTT from the given coordinate values is up to maxRtt, or from
+// the application coordinate of the current host when they are empty
+func (e *Estimator) Within(value []float64, maxRtt time.Duration) ([]m.RankedCoordinate, error) {
+	radius := uh.Milliseconds(maxRtt)
+	if len(value) > 0 {
+		coord, err := e.coordinate(value)
+		if err != nil {
+			return nil, err
+		}
+		return e.store.Within(coord, radius), nil
+	}
+
+	appCoord, _ := e.vivaldiProtocol.AppCoordinate()
+	return slices.DeleteFunc(e.store.Within(appCoord, radius), func(item m.RankedCoordinate) bool {
+		return item.Node().GetId() == e.nodeId
+	}), nil
+}
+
+// coordinate builds a coordinate from values in the wire format of the coordinate space
+func (e *Estimator) coordinate(value []float64) (m.Coordinate, error) {
+	appCoord, _ := e.vivaldiProtocol.AppCoordinate()
+	if size := len(appCoord.Proto(0).Value); len(value) != size {
+		return nil, fmt.Errorf("%w: %d values instead of %d", ErrInvalidCoordinate, len(value), size)
+	}
+	return e.space.NewCoordinate(append([]float64(nil), value...)), nil
+}
+
+// estimate computes the distance from the application coordinate. The error of the remote coordinate is not gossiped,
+// so the error bound only accounts for the relative error of the current host.
+func (e *Estimator) estimate(remote m.Coordinate) Estimate {
+	appCoord, _ := e.vivaldiProtocol.AppCoordinate()
+	_, localError := e.vivaldiProtocol.Coordinate()
+
+	distance := e.space.GetNorm2Distance(appCoord, remote)
+	return Estimate{
+		Rtt:        uh.DurationFromMs(distance),
+		ErrorBound: uh.DurationFromMs(distance * localError),
+	}
+}
@@ -2,43 +2,48 @@ package services
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
 	u "github.com/AlessandroFinocchi/sdcc_common/utils"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"log"
+	"log/slog"
 	"net"
-	"os"
+	"sdcc_host/config"
+	"sdcc_host/metrics"
 	m "sdcc_host/model"
 	uh "sdcc_host/utils"
-	"sdcc_host/vivaldi"
-	"strconv"
 	"sync"
 	"time"
 )
 
 type MembershipProtocol struct {
 	pb.UnimplementedMembershipServer
-	pView  *m.PartialView
-	mu     *sync.RWMutex
-	logger uh.MyLogger
-	filter vivaldi.Filter
+	pView            *m.PartialView
+	server           *grpc.Server
+	samplingInterval time.Duration
+	mu               *sync.RWMutex
+	logger           *slog.Logger
+	vivaldiProtocol  *VivaldiProtocol // updated with the RTT of every shuffle
+	metrics          *metrics.Metrics
 }
 
-func NewMembershipProtocol(filter vivaldi.Filter) *MembershipProtocol {
-	logging, errL := strconv.ParseBool(os.Getenv(m.LoggingMembershipEnv))
-	if errL != nil {
-		log.Fatalf("Could not read configuration in membership: %v", errL)
-	}
+func NewMembershipProtocol(cfg config.MembershipConfig, vivaldiProtocol *VivaldiProtocol, logger *slog.Logger,
+	mt *metrics.Metrics) *MembershipProtocol {
 	return &MembershipProtocol{
-		mu:     &sync.RWMutex{},
-		logger: uh.NewMyLogger(logging),
-		filter: filter,
+		samplingInterval: cfg.SamplingInterval,
+		mu:               &sync.RWMutex{},
+		logger:           logger,
+		vivaldiProtocol:  vivaldiProtocol,
+		metrics:          mt,
 	}
 }
 
 func (mp *MembershipProtocol) ShufflePeers(ctx context.Context, request *pb.MembershipRequestMessage) (*pb.MembershipReplyMessage, error) {
+	header := mp.vivaldiProtocol.CoordinateHeader() // not to lock the vivaldi protocol while holding the membership lock
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
@@ -46,10 +51,18 @@ func (mp *MembershipProtocol) ShufflePeers(ctx context.Context, request *pb.Memb
 		return nil, err
 	}
 
+	if mp.pView == nil {
+		return nil, status.Error(codes.Unavailable, "partial view is not initialized")
+	}
+
 	if len(request.GetNodes()) > mp.pView.ViewSize {
 		return nil, fmt.Errorf("invalid message")
 	}
 
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		mp.logger.Debug("failed to send coordinate header", uh.Err(err))
+	}
+
 	sendingNodes := mp.pView.GetSendingNodes()
 
 	mp.pView.MergeViews(request.GetNodes())
@@ -57,67 +70,90 @@ func (mp *MembershipProtocol) ShufflePeers(ctx context.Context, request *pb.Memb
 	return &pb.MembershipReplyMessage{Nodes: sendingNodes}, nil
 }
 
-func (mp *MembershipProtocol) StartServer() (string, uint32) {
-	flag.Parse()
-	serverAddress := fmt.Sprintf(":%d", *m.MembershipPort)
-	lis, err := net.Listen("tcp", serverAddress)
+// StartServer starts serving on the given port (0 for an ephemeral one) and returns the address of the server
+func (mp *MembershipProtocol) StartServer(port uint) (string, uint32, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Fatalf("Failed to create listener: %v", err)
+		return "", 0, fmt.Errorf("failed to create membership listener: %w", err)
 	}
 
 	serverIp, err := u.GetIpFromListener(lis)
 	if err != nil {
-		log.Fatalf("Failed to get IP from listener: %v", err)
+		_ = lis.Close()
+		return "", 0, fmt.Errorf("failed to get IP from membership listener: %w", err)
 	}
-	serverPort := uint32(*m.MembershipPort)
+	serverPort := uint32(lis.Addr().(*net.TCPAddr).Port)
 
-	registry := grpc.NewServer()
-	pb.RegisterMembershipServer(registry, mp)
+	mp.server = grpc.NewServer()
+	pb.RegisterMembershipServer(mp.server, mp)
 
 	go func() {
-		err = registry.Serve(lis)
-		if err != nil {
-			log.Fatalf("Failed to serve: %v", err)
+		if errS := mp.server.Serve(lis); errS != nil {
+			log.Fatalf("Failed to serve: %v", errS)
 		}
 	}()
 
-	return serverIp, serverPort
+	return serverIp, serverPort, nil
 }
 
-func (mp *MembershipProtocol) StartClient() {
+func (mp *MembershipProtocol) StartClient(ctx context.Context) {
 	if mp.pView == nil {
 		log.Fatalf("Partial view is not initialized")
 	}
 
-	samplingInterval, err := u.ReadConfigInt("config.ini", "membership", "sampling_interval")
-	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
-	}
-
 	// Distribute the coordinates
-	ticker := time.NewTicker(time.Duration(samplingInterval) * time.Second)
-	for range ticker.C {
-		desc, ok := mp.pView.GetRandomDescriptor()
-		if ok {
-			request := &pb.MembershipRequestMessage{
-				Nodes:  mp.pView.GetSendingNodes(),
-				Source: mp.pView.GetCurrentServerNode(),
-			}
-
-			startTime := time.Now().In(m.Location)
-			reply, errM := desc.ShufflePeers(request)
-			rtt := time.Since(startTime)
-			mp.filter.FilterCoordinates(desc.GetReceiverNode().GetId(), rtt)
-			if errM != nil {
-				mp.logger.Log(fmt.Sprintf("failed to shuffle peers: %v\n", errM))
-				mp.pView.RemoveDescriptor(desc)
-			} else {
-				mp.pView.MergeViews(reply.GetNodes())
-			}
+	ticker := time.NewTicker(mp.samplingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mp.shuffle(ctx)
 		}
 	}
 }
 
+func (mp *MembershipProtocol) shuffle(ctx context.Context) {
+	desc, ok := mp.pView.GetRandomDescriptor()
+	if !ok {
+		return
+	}
+
+	request := &pb.MembershipRequestMessage{
+		Nodes:  mp.pView.GetSendingNodes(),
+		Source: mp.pView.GetCurrentServerNode(),
+	}
+
+	var header metadata.MD
+	startTime := time.Now().In(m.Location)
+	reply, errM := desc.ShufflePeers(ctx, request, grpc.Header(&header))
+	rtt := time.Since(startTime)
+	if ctx.Err() != nil { // the host is shutting down, the peer is not faulty
+		return
+	}
+	if errM != nil {
+		mp.logger.Warn("failed to shuffle peers", uh.PeerId(desc.GetReceiverNode().GetId()), uh.Err(errM))
+		mp.metrics.RpcFailed(metrics.RpcShuffle)
+		mp.pView.RemoveDescriptor(desc)
+		return
+	}
+
+	mp.pView.MergeViews(reply.GetNodes())
+	if coords, ok := coordinateFromHeader(header); ok {
+		mp.vivaldiProtocol.AddSample(ctx, metrics.ProtocolMembership, desc.GetReceiverNode().GetId(), coords, rtt, startTime)
+	} else {
+		mp.metrics.ObserveRawRtt(metrics.ProtocolMembership, rtt)
+	}
+}
+
+// Stop gracefully stops the membership server, waiting for the pending requests
+func (mp *MembershipProtocol) Stop() {
+	if mp.server != nil {
+		mp.server.GracefulStop()
+	}
+}
+
 func (mp *MembershipProtocol) SetPartialView(view *m.PartialView) {
 	if mp.pView == nil {
 		mp.pView = view
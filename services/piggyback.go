@@ -0,0 +1,38 @@
+package services
+
+import (
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// coordinateHeader is the header in which the membership and gossip servers send their system coordinate and its
+// error, so that their clients can use the RTT of every call as a vivaldi sample without any extra message. Being a
+// header, it is ignored by the hosts not reading it.
+const coordinateHeader = "vivaldi-coordinate-bin"
+
+// CoordinateHeader returns the header carrying the system coordinate and its error
+func (v *VivaldiProtocol) CoordinateHeader() metadata.MD {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	value, err := proto.Marshal(v.sysCoord.Proto(v.error))
+	if err != nil {
+		return metadata.MD{}
+	}
+	return metadata.Pairs(coordinateHeader, string(value))
+}
+
+// coordinateFromHeader returns the system coordinate sent by a server in the header of its reply, if any
+func coordinateFromHeader(header metadata.MD) (*pb.VivaldiCoordinate, bool) {
+	values := header.Get(coordinateHeader)
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	coord := &pb.VivaldiCoordinate{}
+	if err := proto.Unmarshal([]byte(values[0]), coord); err != nil {
+		return nil, false
+	}
+	return coord, true
+}
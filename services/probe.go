@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sdcc_host/config"
+	uh "sdcc_host/utils"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// A probe is made of a magic number, a sequence number and the time it was sent, which the probe server echoes back
+// unchanged. The magic number makes the server ignore any other datagram, and the sequence number makes the prober
+// ignore the late replies to the probes it already considered lost.
+const (
+	probeMagic uint32 = 0x53444343 // "SDCC"
+	probeSize         = 4 + 8 + 8
+)
+
+var ErrNoProbeReply = errors.New("no probe answered")
+
+// ProbeServer echoes back the probes received on a UDP port, so that the RTT measured by the prober only includes the
+// network and the kernels of the two hosts, without the gRPC overhead and the lock contention of the vivaldi server
+type ProbeServer struct {
+	conn   *net.UDPConn
+	logger *slog.Logger
+}
+
+func NewProbeServer(logger *slog.Logger) *ProbeServer {
+	return &ProbeServer{logger: logger}
+}
+
+// Start starts echoing the probes received on the given UDP port
+func (p *ProbeServer) Start(port uint32) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		return fmt.Errorf("failed to create probe listener: %w", err)
+	}
+	p.conn = conn
+
+	go p.serve()
+
+	return nil
+}
+
+func (p *ProbeServer) serve() {
+	buffer := make([]byte, probeSize+1) // one more byte to detect the longer datagrams
+	for {
+		n, addr, err := p.conn.ReadFromUDP(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			p.logger.Debug("failed to read probe", uh.Err(err))
+			continue
+		}
+		if n != probeSize || binary.BigEndian.Uint32(buffer) != probeMagic {
+			continue
+		}
+
+		if _, err = p.conn.WriteToUDP(buffer[:n], addr); err != nil {
+			p.logger.Debug("failed to answer probe", slog.String("address", addr.String()), uh.Err(err))
+		}
+	}
+}
+
+func (p *ProbeServer) Stop() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+}
+
+// Prober measures the RTT to a probe server sending it several probes, one after the other
+type Prober struct {
+	count     int
+	aggregate string // "min" or "median"
+	timeout   time.Duration
+	sequence  atomic.Uint64
+}
+
+func NewProber(cfg config.VivaldiConfig) *Prober {
+	return &Prober{
+		count:     cfg.ProbeCount,
+		aggregate: cfg.ProbeAggregate,
+		timeout:   cfg.ProbeTimeout,
+	}
+}
+
+// Probe returns the minimum or the median RTT of the probes answered by the probe server at address, and
+// ErrNoProbeReply if none was answered in time
+func (p *Prober) Probe(ctx context.Context, address string) (time.Duration, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to probe server: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The send times are relative to start, so that the RTTs are measured with the monotonic clock
+	start := time.Now()
+	request := make([]byte, probeSize)
+	reply := make([]byte, probeSize+1)
+	rtts := make([]time.Duration, 0, p.count)
+	for i := 0; i < p.count && ctx.Err() == nil; i++ {
+		sequence := p.sequence.Add(1)
+		binary.BigEndian.PutUint32(request, probeMagic)
+		binary.BigEndian.PutUint64(request[4:], sequence)
+		binary.BigEndian.PutUint64(request[12:], uint64(time.Since(start)))
+		if _, err = conn.Write(request); err != nil {
+			return 0, fmt.Errorf("failed to send probe: %w", err)
+		}
+
+		rtt, errA := p.await(conn, reply, sequence, start)
+		var netErr net.Error
+		if errors.As(errA, &netErr) && netErr.Timeout() {
+			continue // the probe or its reply is lost
+		}
+		if errA != nil {
+			return 0, fmt.Errorf("failed to receive probe reply: %w", errA)
+		}
+		rtts = append(rtts, rtt)
+	}
+
+	if len(rtts) == 0 {
+		return 0, fmt.Errorf("%w out of %d", ErrNoProbeReply, p.count)
+	}
+	return p.aggregateRtts(rtts), nil
+}
+
+// await waits for the reply to the probe with the given sequence number, discarding the replies to the previous ones
+func (p *Prober) await(conn net.Conn, reply []byte, sequence uint64, start time.Time) (time.Duration, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(p.timeout)); err != nil {
+		return 0, err
+	}
+
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return 0, err
+		}
+		if n != probeSize || binary.BigEndian.Uint32(reply) != probeMagic ||
+			binary.BigEndian.Uint64(reply[4:]) != sequence {
+			continue
+		}
+		return time.Since(start) - time.Duration(binary.BigEndian.Uint64(reply[12:])), nil
+	}
+}
+
+func (p *Prober) aggregateRtts(rtts []time.Duration) time.Duration {
+	slices.Sort(rtts)
+	if p.aggregate == "min" {
+		return rtts[0]
+	}
+
+	middle := len(rtts) / 2
+	if len(rtts)%2 == 0 {
+		return (rtts[middle-1] + rtts[middle]) / 2
+	}
+	return rtts[middle]
+}
@@ -4,42 +4,136 @@ import (
 	"context"
 	"fmt"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
-	"github.com/google/uuid"
 	"google.golang.org/grpc"
-	"log"
-	"os"
+	"google.golang.org/grpc/credentials"
+	"log/slog"
+	"math/rand"
+	"sdcc_host/config"
 	m "sdcc_host/model"
 	uh "sdcc_host/utils"
-	"strconv"
+	"sync"
 	"time"
 )
 
+const connectAttemptTimeout = 5 * time.Second
+
+// RegistryState tells whether the host is currently registered to the registry
+type RegistryState int
+
 const (
-	//address = "0.0.0.0:50051" // For local testing
-	address = "10.0.0.253:50051"
+	RegistryDisconnected RegistryState = iota // never connected, or shut down
+	RegistryConnected                         // heartbeats are acknowledged by the registry
+	RegistryDegraded                          // the registry is unreachable: the host keeps running and tries to re-register
 )
 
+func (s RegistryState) String() string {
+	switch s {
+	case RegistryConnected:
+		return "connected"
+	case RegistryDegraded:
+		return "degraded"
+	default:
+		return "disconnected"
+	}
+}
+
 type RegistryConnectorClient struct {
-	logger uh.MyLogger
+	addresses         []string
+	connectDeadline   time.Duration
+	backoffInitial    time.Duration
+	backoffMax        time.Duration
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	tlsCredentials    credentials.TransportCredentials
+	pView             *m.PartialView
+	state             RegistryState
+	mu                *sync.RWMutex
+	wg                *sync.WaitGroup // tracks the heartbeat
+	logger            *slog.Logger
 }
 
-func NewRegistryConnectorClient() (*RegistryConnectorClient, string) {
-	currUUID, err := uuid.NewUUID()
-	logging, errL := strconv.ParseBool(os.Getenv(m.LoggingEnv))
-	if err != nil || errL != nil {
-		log.Fatalf("Error in registry connector: %v", err)
+func NewRegistryConnectorClient(cfg config.RegistryConfig, logger *slog.Logger) *RegistryConnectorClient {
+	return &RegistryConnectorClient{
+		addresses:         cfg.Addresses,
+		connectDeadline:   cfg.ConnectDeadline,
+		backoffInitial:    cfg.BackoffInitial,
+		backoffMax:        cfg.BackoffMax,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		heartbeatTimeout:  cfg.HeartbeatTimeout,
+		state:             RegistryDisconnected,
+		mu:                &sync.RWMutex{},
+		wg:                &sync.WaitGroup{},
+		logger:            logger,
 	}
+}
 
-	fmt.Println("Current Host UUID: ", currUUID.String())
+// Wait waits for the heartbeat started by Connect to stop, once the context passed to Connect is done
+func (rc *RegistryConnectorClient) Wait() {
+	rc.wg.Wait()
+}
+
+// State returns whether the host is currently registered to the registry
+func (rc *RegistryConnectorClient) State() RegistryState {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.state
+}
+
+func (rc *RegistryConnectorClient) setState(state RegistryState) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.state != state {
+		rc.logger.Info("registry state changed", slog.String("from", rc.state.String()), slog.String("to", state.String()))
+	}
+	rc.state = state
+}
 
-	return &RegistryConnectorClient{uh.NewMyLogger(logging)}, currUUID.String()
+// SetPartialView sets the view in which the nodes received when re-registering to the registry are merged
+func (rc *RegistryConnectorClient) SetPartialView(view *m.PartialView) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.pView == nil {
+		rc.pView = view
+	}
 }
 
-func (rc *RegistryConnectorClient) startHeartbeat(h pb.HeartbeatClient, ctx context.Context, currentNode *pb.Node) {
+// startHeartbeat periodically beats the registry. When a beat fails the host enters the degraded state and tries to
+// re-register through all the registry replicas with an exponential backoff, until one of them accepts it again.
+func (rc *RegistryConnectorClient) startHeartbeat(ctx context.Context, conn *grpc.ClientConn, currentNode *pb.Node) {
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		rc.setState(RegistryDisconnected)
+	}()
+
+	backoff := rc.backoffInitial
 	for {
-		time.Sleep(4 * time.Second)
-		ctxT, cancel := context.WithTimeout(ctx, 10*time.Second)
-		_, err := h.Beat(ctxT, &pb.Node{
+		wait := rc.heartbeatInterval
+		if conn == nil {
+			wait = jitter(backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if conn == nil { // degraded: try to re-register
+			var nodeList *pb.NodeList
+			conn, nodeList = rc.reconnect(ctx, currentNode)
+			if conn == nil {
+				backoff = min(2*backoff, rc.backoffMax)
+				continue
+			}
+			backoff = rc.backoffInitial
+			rc.setState(RegistryConnected)
+			rc.mergeNodes(nodeList.GetNodes())
+			continue
+		}
+
+		ctxT, cancel := context.WithTimeout(ctx, rc.heartbeatTimeout)
+		_, err := pb.NewHeartbeatClient(conn).Beat(ctxT, &pb.Node{
 			Id:             currentNode.GetId(),
 			MembershipIp:   currentNode.GetMembershipIp(),
 			MembershipPort: currentNode.GetMembershipPort(),
@@ -47,39 +141,112 @@ func (rc *RegistryConnectorClient) startHeartbeat(h pb.HeartbeatClient, ctx cont
 			VivaldiPort:    currentNode.GetVivaldiPort(),
 			GossipIp:       currentNode.GetGossipIp(),
 			GossipPort:     currentNode.GetGossipPort()})
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			log.Fatalf("Could not send heartbeat: %v", err)
+			rc.logger.Warn("could not send heartbeat, registry is unreachable", uh.Err(err))
+			_ = conn.Close()
+			conn = nil
+			rc.setState(RegistryDegraded)
 		}
-		cancel()
 	}
 }
 
-func (rc *RegistryConnectorClient) Connect(ctx context.Context, currentServerNode *pb.Node) []*pb.Node {
+func (rc *RegistryConnectorClient) reconnect(ctx context.Context, currentServerNode *pb.Node) (*grpc.ClientConn, *pb.NodeList) {
+	for _, address := range rc.addresses {
+		conn, nodeList, err := rc.connectTo(ctx, address, currentServerNode)
+		if err == nil {
+			rc.logger.Info("re-registered to registry", slog.String("registry", address))
+			return conn, nodeList
+		}
+		rc.logger.Debug("could not re-register to registry", slog.String("registry", address), uh.Err(err))
+	}
+	return nil, nil
+}
+
+func (rc *RegistryConnectorClient) mergeNodes(nodes []*pb.Node) {
+	rc.mu.RLock()
+	pView := rc.pView
+	rc.mu.RUnlock()
+	if pView != nil {
+		pView.MergeViews(nodes)
+	}
+}
+
+// Connect registers the current node to the registry and returns the node list to bootstrap the partial view.
+// Every registry replica is tried in order; when all of them fail the next round starts after an exponential
+// backoff with jitter, until the connection deadline expires.
+func (rc *RegistryConnectorClient) Connect(ctx context.Context, currentServerNode *pb.Node) ([]*pb.Node, error) {
 	tlsCredentials, err := uh.LoadClientTLSCredentials()
 	if err != nil {
-		log.Fatal("cannot load TLS credentials: ", err)
+		return nil, fmt.Errorf("cannot load TLS credentials: %w", err)
 	}
+	rc.tlsCredentials = tlsCredentials
 
-	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(tlsCredentials))
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+	ctxD, cancel := context.WithTimeout(ctx, rc.connectDeadline)
+	defer cancel()
+
+	backoff := rc.backoffInitial
+	for {
+		for _, address := range rc.addresses {
+			conn, nodeList, errC := rc.connectTo(ctxD, address, currentServerNode)
+			if errC == nil {
+				return rc.connected(ctx, conn, nodeList, currentServerNode), nil
+			}
+			err = errC
+			rc.logger.Warn("could not connect to registry", slog.String("registry", address), uh.Err(errC))
+		}
+
+		select {
+		case <-ctxD.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("could not connect to any registry within %v: %w", rc.connectDeadline, err)
+		case <-time.After(jitter(backoff)):
+		}
+		backoff = min(2*backoff, rc.backoffMax)
 	}
+}
 
-	c := pb.NewConnectorClient(conn)
-	h := pb.NewHeartbeatClient(conn)
+func (rc *RegistryConnectorClient) connectTo(ctx context.Context, address string, currentServerNode *pb.Node) (*grpc.ClientConn, *pb.NodeList, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(rc.tlsCredentials))
+	if err != nil {
+		return nil, nil, err
+	}
 
-	nodeList, err := c.Connect(ctx, currentServerNode)
+	ctxT, cancel := context.WithTimeout(ctx, connectAttemptTimeout)
+	defer cancel()
+	nodeList, err := pb.NewConnectorClient(conn).Connect(ctxT, currentServerNode)
 	if err != nil {
-		log.Fatalf("Could not connect: %v", err)
+		_ = conn.Close()
+		return nil, nil, err
 	}
 
-	rc.logger.Log("Node list received:")
-	for _, node := range nodeList.Nodes {
-		rc.logger.Log(fmt.Sprintf("Node: %s %d:%d:%d", node.GetId(), node.GetMembershipPort(), node.GetVivaldiPort(), node.GetGossipPort()))
+	return conn, nodeList, nil
+}
+
+func (rc *RegistryConnectorClient) connected(ctx context.Context, conn *grpc.ClientConn, nodeList *pb.NodeList,
+	currentServerNode *pb.Node) []*pb.Node {
+	rc.logger.Info("connected to registry", slog.Int("nodes", len(nodeList.GetNodes())))
+	for _, node := range nodeList.GetNodes() {
+		rc.logger.Debug("node received from registry", uh.PeerId(node.GetId()),
+			slog.Any("ports", []uint32{node.GetMembershipPort(), node.GetVivaldiPort(), node.GetGossipPort()}))
 	}
-	rc.logger.Log("")
 
-	go rc.startHeartbeat(h, ctx, currentServerNode)
+	rc.setState(RegistryConnected)
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+		rc.startHeartbeat(ctx, conn, currentServerNode)
+	}()
 
 	return nodeList.Nodes
 }
+
+// jitter returns a random duration between half and the whole backoff
+func jitter(backoff time.Duration) time.Duration {
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
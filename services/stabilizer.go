@@ -1,14 +1,16 @@
 package services
 
 import (
-	"fmt"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
-	u "github.com/AlessandroFinocchi/sdcc_common/utils"
 	"log"
-	"os"
+	"log/slog"
+	"sdcc_host/config"
 	m "sdcc_host/model"
+	"sdcc_host/results"
 	uh "sdcc_host/utils"
-	"strconv"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,48 +25,54 @@ type Stabilizer struct {
 	intervalUpdate time.Duration // maximum time between updates
 	wsCentroid     m.Coordinate  // centroid of start window
 	coordDimension int
+	space          m.Space
 	vivaldiGossip  *VivaldiGossip
-	logger         uh.MyLogger
+	mu             *sync.RWMutex
+	logger         *slog.Logger
+	resultSink     results.ResultSink
 }
 
-func NewStabilizer(vivaldiGossip *VivaldiGossip) *Stabilizer {
-	windowSize, err1 := u.ReadConfigInt("config.ini", "vivaldi", "windowSize")
-	tau, err2 := u.ReadConfigFloat64("config.ini", "vivaldi", "tau")
-	epsilonR, err3 := u.ReadConfigFloat64("config.ini", "vivaldi", "epsilon_r")
-	dimension, err4 := u.ReadConfigInt("config.ini", "vivaldi", "coordinate_dimensions")
-	intervalUpdate, err5 := u.ReadConfigInt("config.ini", "vivaldi_gossip", "retention_seconds")
-	logging, errL := strconv.ParseBool(os.Getenv(m.LoggingGossipEnv))
-	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil || errL != nil {
-		log.Fatalf("Failed to read config in stabilizer")
-	}
+// StabilizerState is the state of the stabilizer saved across restarts
+type StabilizerState struct {
+	StartWindow   [][]float64 `json:"start_window"`
+	CurrentWindow [][]float64 `json:"current_window"`
+	AppCoordinate []float64   `json:"app_coordinate"`
+	LastUpdate    time.Time   `json:"last_update"`
+}
 
-	if m.SpaceType == 2 {
-		dimension += 1 // for height
-	}
+func NewStabilizer(cfg *config.HostConfig, space m.Space, vivaldiGossip *VivaldiGossip, logger *slog.Logger,
+	resultSink results.ResultSink) *Stabilizer {
+	dimension := space.VectorSize(cfg.Vivaldi.CoordinateDimensions)
 
 	return &Stabilizer{
 		startWindow:    make([]m.Coordinate, 0),
 		currentWindow:  make([]m.Coordinate, 0),
-		windowSize:     windowSize,
-		tau:            tau,
-		epsilonR:       epsilonR,
-		appCoord:       m.InstanceSpace.NewCoordinate(make([]float64, dimension)),
+		windowSize:     cfg.Vivaldi.WindowSize,
+		tau:            cfg.Vivaldi.Tau,
+		epsilonR:       cfg.Vivaldi.EpsilonR,
+		appCoord:       space.NewCoordinate(make([]float64, dimension)),
 		lastUpdate:     time.Now().In(m.Location),
-		intervalUpdate: time.Duration(intervalUpdate/4) * time.Second,
+		intervalUpdate: cfg.VivaldiGossip.Retention / 4,
 		coordDimension: dimension,
+		space:          space,
 		vivaldiGossip:  vivaldiGossip,
-		logger:         uh.NewMyLogger(logging),
+		mu:             &sync.RWMutex{},
+		logger:         logger,
+		resultSink:     resultSink,
 	}
 }
 
 func (s *Stabilizer) Update(systemCoord *m.Coordinate, node *pb.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if len(s.startWindow) != len(s.currentWindow) {
 		log.Fatalf("Window sizes are not equal")
 	}
 
 	if time.Since(s.lastUpdate) > s.intervalUpdate {
 		s.lastUpdate = time.Now().In(m.Location)
-		gossipCoord := m.NewGossipCoordinate(s.appCoord, node, s.lastUpdate, s.vivaldiGossip.MaxFeedbackCounter())
+		gossipCoord := m.NewGossipCoordinate(s.copyAppCoord(), node, s.lastUpdate, s.vivaldiGossip.MaxFeedbackCounter())
 		s.vivaldiGossip.Update(m.GossipCoordinate2Proto(gossipCoord))
 	}
 
@@ -72,42 +80,127 @@ func (s *Stabilizer) Update(systemCoord *m.Coordinate, node *pb.Node) {
 		s.startWindow = append(s.startWindow, *systemCoord)
 		s.currentWindow = append(s.currentWindow, *systemCoord)
 		if len(s.startWindow) == s.windowSize {
-			s.wsCentroid = m.InstanceSpace.ComputeCentroid(s.startWindow)
+			s.wsCentroid = s.space.ComputeCentroid(s.startWindow)
 		}
 	} else {
 		s.currentWindow = append(s.currentWindow[1:], *systemCoord)
-		wcCentroid := m.InstanceSpace.ComputeCentroid(s.currentWindow)
+		wcCentroid := s.space.ComputeCentroid(s.currentWindow)
 
-		relativeCheck := s.checkRelative(wcCentroid, systemCoord)
+		relativeCheck := s.checkRelative(wcCentroid)
 		energyCheck := s.checkEnergy(wcCentroid)
 
 		check := energyCheck || relativeCheck
 
 		if check {
-			s.logger.Log("Coordinate updated")
-			s.logger.Log(fmt.Sprintf("System coordinate: %v", (*systemCoord).Proto(1).Value))
-			s.logger.Log(fmt.Sprintf("App coordinate:     %v\n", s.appCoord.Proto(1).Value))
-			_ = os.Stdout.Sync()
+			s.logger.Debug("application coordinate updated",
+				slog.Any("system_coord", (*systemCoord).Proto(1).Value),
+				slog.Any("app_coord", s.appCoord.Proto(1).Value),
+				slog.Bool("energy_check", energyCheck),
+				slog.Bool("relative_check", relativeCheck))
 
 			s.startWindow = s.startWindow[:0]
 			s.currentWindow = s.currentWindow[:0]
 
 			s.lastUpdate = time.Now().In(m.Location)
-			gossipCoord := m.NewGossipCoordinate(s.appCoord, node, s.lastUpdate, s.vivaldiGossip.MaxFeedbackCounter())
+			gossipCoord := m.NewGossipCoordinate(s.copyAppCoord(), node, s.lastUpdate, s.vivaldiGossip.MaxFeedbackCounter())
 			s.vivaldiGossip.Update(m.GossipCoordinate2Proto(gossipCoord))
+
+			reasons := make([]string, 0, 2)
+			if energyCheck {
+				reasons = append(reasons, "energy")
+			}
+			if relativeCheck {
+				reasons = append(reasons, "relative")
+			}
+			errR := s.resultSink.WriteAppUpdate(results.AppUpdate{
+				Time:       s.lastUpdate,
+				Coordinate: gossipCoord.Coord().Proto(0).Value,
+				Reason:     strings.Join(reasons, "+"),
+			})
+			if errR != nil {
+				s.logger.Error("failed to write result", uh.Err(errR))
+			}
 		}
 	}
 }
 
-func (s *Stabilizer) checkRelative(wcCentroid m.Coordinate, systemCoord *m.Coordinate) bool {
+// AppCoordinate returns a copy of the current application coordinate and the last time it was gossiped
+func (s *Stabilizer) AppCoordinate() (m.Coordinate, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.copyAppCoord(), s.lastUpdate
+}
+
+// State returns a copy of the windows and of the application coordinate
+func (s *Stabilizer) State() StabilizerState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return StabilizerState{
+		StartWindow:   coordinateValues(s.startWindow),
+		CurrentWindow: coordinateValues(s.currentWindow),
+		AppCoordinate: s.copyAppCoord().Proto(0).Value,
+		LastUpdate:    s.lastUpdate,
+	}
+}
+
+// Restore replaces the windows and the application coordinate with the saved ones. The windows are dropped if their
+// sizes do not match the configuration, and every coordinate with the wrong number of values is ignored.
+func (s *Stabilizer) Restore(state StabilizerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(state.AppCoordinate) == s.coordDimension {
+		s.appCoord = s.space.NewCoordinate(slices.Clone(state.AppCoordinate))
+		s.lastUpdate = state.LastUpdate
+	}
+
+	startWindow, okS := s.coordinates(state.StartWindow)
+	currentWindow, okC := s.coordinates(state.CurrentWindow)
+	if !okS || !okC || len(startWindow) != len(currentWindow) || len(startWindow) > s.windowSize {
+		return
+	}
+	s.startWindow, s.currentWindow = startWindow, currentWindow
+	if len(s.startWindow) == s.windowSize {
+		s.wsCentroid = s.space.ComputeCentroid(s.startWindow)
+	}
+}
+
+func (s *Stabilizer) coordinates(values [][]float64) ([]m.Coordinate, bool) {
+	coords := make([]m.Coordinate, 0, len(values))
+	for _, value := range values {
+		if len(value) != s.coordDimension {
+			return nil, false
+		}
+		coords = append(coords, s.space.NewCoordinate(slices.Clone(value)))
+	}
+	return coords, true
+}
+
+func coordinateValues(coords []m.Coordinate) [][]float64 {
+	values := make([][]float64, 0, len(coords))
+	for _, coord := range coords {
+		values = append(values, slices.Clone(coord.Proto(0).Value))
+	}
+	return values
+}
+
+// copyAppCoord copies the application coordinate, which is updated in place when the energy or relative check passes
+func (s *Stabilizer) copyAppCoord() m.Coordinate {
+	return s.space.NewCoordinate(append([]float64(nil), s.appCoord.Proto(0).Value...))
+}
+
+// checkRelative moves the application coordinate to the centroid of the current window when the window drifted far,
+// compared to the distance from the nearest neighbour. The system coordinate is a copy owned by the vivaldi protocol,
+// and is left as it is.
+func (s *Stabilizer) checkRelative(wcCentroid m.Coordinate) bool {
 	neighbour, ok := s.vivaldiGossip.GetNeighbour()
 	if !ok {
 		return false
 	}
 
-	relative := m.InstanceSpace.GetNorm2Distance(s.wsCentroid, wcCentroid) / m.InstanceSpace.GetNorm2Distance(s.wsCentroid, neighbour)
+	relative := s.space.GetNorm2Distance(s.wsCentroid, wcCentroid) / s.space.GetNorm2Distance(s.wsCentroid, neighbour)
 	if relative > s.epsilonR {
-		copy((*systemCoord).GetPoint(), wcCentroid.GetPoint())
+		copy(s.appCoord.GetPoint(), wcCentroid.GetPoint())
 		return true
 	}
 
@@ -117,9 +210,9 @@ func (s *Stabilizer) checkRelative(wcCentroid m.Coordinate, systemCoord *m.Coord
 func (s *Stabilizer) checkEnergy(wcCentroid m.Coordinate) bool {
 	n := float64(s.windowSize)
 
-	scSum := sumOfDistances(s.startWindow, s.currentWindow)
-	ssSum := sumOfDistances(s.startWindow, s.startWindow)
-	ccSum := sumOfDistances(s.currentWindow, s.currentWindow)
+	scSum := sumOfDistances(s.space, s.startWindow, s.currentWindow)
+	ssSum := sumOfDistances(s.space, s.startWindow, s.startWindow)
+	ccSum := sumOfDistances(s.space, s.currentWindow, s.currentWindow)
 
 	e := (2*scSum - ssSum - ccSum) / (2 * n)
 
@@ -131,11 +224,11 @@ func (s *Stabilizer) checkEnergy(wcCentroid m.Coordinate) bool {
 	return false
 }
 
-func sumOfDistances(set1, set2 []m.Coordinate) float64 {
+func sumOfDistances(space m.Space, set1, set2 []m.Coordinate) float64 {
 	sum := 0.0
 	for _, a := range set1 {
 		for _, b := range set2 {
-			sum += m.InstanceSpace.GetNorm2Distance(a, b)
+			sum += space.GetNorm2Distance(a, b)
 		}
 	}
 	return sum
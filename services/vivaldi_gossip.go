@@ -2,19 +2,21 @@ package services
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
 	u "github.com/AlessandroFinocchi/sdcc_common/utils"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net"
-	"os"
+	"sdcc_host/config"
+	"sdcc_host/metrics"
 	m "sdcc_host/model"
 	uh "sdcc_host/utils"
-	"sdcc_host/vivaldi"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -22,42 +24,48 @@ import (
 type VivaldiGossip struct {
 	pb.UnimplementedVivaldiGossipServer
 	store              m.Store
+	space              m.Space
 	pView              *m.PartialView
+	server             *grpc.Server
 	infected           map[string]m.GossipCoordinate
 	removed            map[string]m.GossipCoordinate
 	maxFeedbackCounter int
 	sendingCoordsNum   int
+	samplingInterval   time.Duration
 	mu                 *sync.RWMutex
-	logger             uh.MyLogger
-	filter             vivaldi.Filter
+	logger             *slog.Logger
+	vivaldiProtocol    *VivaldiProtocol // updated with the RTT of every gossip round
+	metrics            *metrics.Metrics
 }
 
 func (v *VivaldiGossip) MaxFeedbackCounter() int {
 	return v.maxFeedbackCounter
 }
 
-func NewVivaldiGossip(filter vivaldi.Filter) *VivaldiGossip {
-	maxFeedbackCounter, err1 := u.ReadConfigInt("config.ini", "vivaldi_gossip", "feedback_counter")
-	sendingCoordsNum, err2 := u.ReadConfigInt("config.ini", "vivaldi_gossip", "feedback_coords_num")
-	logging, errL := strconv.ParseBool(os.Getenv(m.LoggingGossipEnv))
-	store := m.NewStore()
-	if err1 != nil || err2 != nil || errL != nil {
-		log.Fatalf("Failed to read config for gossiping vivaldi")
-	}
+func NewVivaldiGossip(cfg *config.HostConfig, space m.Space, logger *slog.Logger, mt *metrics.Metrics) *VivaldiGossip {
+	store := m.NewStore(cfg, space, logger)
 
 	return &VivaldiGossip{
 		store:              store,
+		space:              space,
 		infected:           make(map[string]m.GossipCoordinate),
 		removed:            make(map[string]m.GossipCoordinate),
-		maxFeedbackCounter: maxFeedbackCounter,
-		sendingCoordsNum:   sendingCoordsNum,
+		maxFeedbackCounter: cfg.VivaldiGossip.FeedbackCounter,
+		sendingCoordsNum:   cfg.VivaldiGossip.FeedbackCoordsNum,
+		samplingInterval:   cfg.VivaldiGossip.SamplingInterval,
 		mu:                 &sync.RWMutex{},
-		logger:             uh.NewMyLogger(logging),
-		filter:             filter,
+		logger:             logger,
+		metrics:            mt,
 	}
 }
 
 func (v *VivaldiGossip) Gossip(ctx context.Context, coords *pb.GossipCoordinateList) (*pb.GossipCoordinateList, error) {
+	// The header locks the vivaldi protocol, which locks the gossip while updating the stabilizer, so it is built first
+	var header metadata.MD
+	if v.vivaldiProtocol != nil {
+		header = v.vivaldiProtocol.CoordinateHeader()
+	}
+
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -65,71 +73,114 @@ func (v *VivaldiGossip) Gossip(ctx context.Context, coords *pb.GossipCoordinateL
 		return nil, err
 	}
 
-	sendingCoords := v.Update(coords.GetCoordinates()...)
+	if v.pView == nil {
+		return nil, status.Error(codes.Unavailable, "partial view is not initialized")
+	}
+
+	if header != nil {
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			v.logger.Debug("failed to send coordinate header", uh.Err(err))
+		}
+	}
+
+	sendingCoords := v.update(coords.GetCoordinates()...)
 
 	return &pb.GossipCoordinateList{Coordinates: sendingCoords}, nil
 }
 
-func (v *VivaldiGossip) StartServer() (string, uint32) {
-	flag.Parse()
-	serverAddress := fmt.Sprintf(":%d", *m.GossipPort)
-	lis, err := net.Listen("tcp", serverAddress)
+// StartServer starts serving on the given port (0 for an ephemeral one) and returns the address of the server
+func (v *VivaldiGossip) StartServer(port uint) (string, uint32, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Fatalf("Failed to create listener: %v", err)
+		return "", 0, fmt.Errorf("failed to create gossip listener: %w", err)
 	}
 
 	serverIp, err := u.GetIpFromListener(lis)
 	if err != nil {
-		log.Fatalf("Failed to get IP from listener: %v", err)
+		_ = lis.Close()
+		return "", 0, fmt.Errorf("failed to get IP from gossip listener: %w", err)
 	}
-	serverPort := uint32(*m.GossipPort)
+	serverPort := uint32(lis.Addr().(*net.TCPAddr).Port)
 
-	registry := grpc.NewServer()
-	pb.RegisterVivaldiGossipServer(registry, v)
+	v.server = grpc.NewServer()
+	pb.RegisterVivaldiGossipServer(v.server, v)
 
 	go func() {
-		err = registry.Serve(lis)
-		if err != nil {
-			log.Fatalf("Failed to serve: %v", err)
+		if errS := v.server.Serve(lis); errS != nil {
+			log.Fatalf("Failed to serve: %v", errS)
 		}
 	}()
 
-	return serverIp, serverPort
+	return serverIp, serverPort, nil
 }
 
-func (v *VivaldiGossip) StartClient() {
+func (v *VivaldiGossip) StartClient(ctx context.Context) {
 	if v.pView == nil {
 		log.Fatalf("Partial view is not initialized")
 	}
 
-	samplingInterval, err := u.ReadConfigInt("config.ini", "vivaldi_gossip", "sampling_interval")
-	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
-	}
+	// Outdated coordinates are deleted in the background, and the client returns once the deletion has stopped
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v.store.DeleteOutdatedItems(ctx)
+	}()
+	defer wg.Wait()
 
 	// Distribute the coordinates
-	ticker := time.NewTicker(time.Duration(samplingInterval) * time.Second)
-	for range ticker.C {
-		desc, ok := v.pView.GetRandomDescriptor()
-		if ok {
-			sentCoords := v.SelectCoordinates()
-			startTime := time.Now().In(m.Location)
-			receivedCoords, errG := desc.GossipCoordinates(sentCoords)
-			rtt := time.Since(startTime)
-			v.filter.FilterCoordinates(desc.GetReceiverNode().GetId(), rtt)
-			if errG != nil {
-				v.logger.Log(fmt.Sprintf("Failed to gossip coordinates: %v\n", errG))
-				v.pView.RemoveDescriptor(desc)
-				v.removeInfected(desc.GetReceiverNode().GetId())
-				v.removeRemoved(desc.GetReceiverNode().GetId())
-			} else {
-				v.Update(receivedCoords.GetCoordinates()...)
-				v.store.PrintItems()
-			}
+	ticker := time.NewTicker(v.samplingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v.gossip(ctx)
+		}
+	}
+}
+
+func (v *VivaldiGossip) gossip(ctx context.Context) {
+	desc, ok := v.pView.GetRandomDescriptor()
+	if !ok {
+		return
+	}
+
+	var header metadata.MD
+	sentCoords := v.SelectCoordinates()
+	startTime := time.Now().In(m.Location)
+	receivedCoords, errG := desc.GossipCoordinates(ctx, sentCoords, grpc.Header(&header))
+	rtt := time.Since(startTime)
+	if ctx.Err() != nil { // the host is shutting down, the peer is not faulty
+		return
+	}
+	if errG != nil {
+		v.logger.Warn("failed to gossip coordinates", uh.PeerId(desc.GetReceiverNode().GetId()), uh.Err(errG))
+		v.metrics.RpcFailed(metrics.RpcGossip)
+		v.pView.RemoveDescriptor(desc)
+		v.mu.Lock()
+		v.removeInfected(desc.GetReceiverNode().GetId())
+		v.removeRemoved(desc.GetReceiverNode().GetId())
+		v.mu.Unlock()
+	} else {
+		v.Update(receivedCoords.GetCoordinates()...)
+		v.store.PrintItems()
+		if coords, ok := coordinateFromHeader(header); ok && v.vivaldiProtocol != nil {
+			v.vivaldiProtocol.AddSample(ctx, metrics.ProtocolGossip, desc.GetReceiverNode().GetId(), coords, rtt, startTime)
+		} else {
+			v.metrics.ObserveRawRtt(metrics.ProtocolGossip, rtt)
 		}
 	}
 }
 
+// Stop gracefully stops the gossip server, waiting for the pending requests
+func (v *VivaldiGossip) Stop() {
+	if v.server != nil {
+		v.server.GracefulStop()
+	}
+}
+
 func (v *VivaldiGossip) SelectCoordinates() *pb.GossipCoordinateList {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
@@ -157,7 +208,15 @@ func (v *VivaldiGossip) SelectCoordinates() *pb.GossipCoordinateList {
 	return &pb.GossipCoordinateList{Coordinates: selected}
 }
 
+// Update merges the received coordinates into the infected and removed sets, and returns the coordinates to send back
+// as feedback
 func (v *VivaldiGossip) Update(gossipCoord ...*pb.GossipCoordinate) []*pb.GossipCoordinate {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.update(gossipCoord...)
+}
+
+func (v *VivaldiGossip) update(gossipCoord ...*pb.GossipCoordinate) []*pb.GossipCoordinate {
 	var sendingCoords = make([]*pb.GossipCoordinate, 0)
 
 	for _, receivedCoord := range gossipCoord {
@@ -186,7 +245,7 @@ func (v *VivaldiGossip) Update(gossipCoord ...*pb.GossipCoordinate) []*pb.Gossip
 }
 
 func (v *VivaldiGossip) addInfected(coord *pb.GossipCoordinate) {
-	v.infected[coord.GetNode().GetId()] = m.Proto2GossipCoordinate(coord, v.maxFeedbackCounter)
+	v.infected[coord.GetNode().GetId()] = m.Proto2GossipCoordinate(v.space, coord, v.maxFeedbackCounter)
 	v.updateStore(coord)
 }
 func (v *VivaldiGossip) removeInfected(key string) {
@@ -200,7 +259,7 @@ func (v *VivaldiGossip) removeRemoved(key string) {
 }
 func (v *VivaldiGossip) updateStore(receivedCoord *pb.GossipCoordinate) {
 	// store new coordinate
-	storingCoord := m.Proto2GossipCoordinate(receivedCoord, 0)
+	storingCoord := m.Proto2GossipCoordinate(v.space, receivedCoord, 0)
 	v.store.Save(storingCoord)
 
 	// If the received coordinate is from the current server, do not update it as a neighbour
@@ -223,9 +282,27 @@ func (v *VivaldiGossip) getCurrentAppCoord() (m.GossipCoordinate, bool) {
 		return coordR, false
 	}
 }
+
+// SetSizes returns the number of coordinates in the infected and removed sets
+func (v *VivaldiGossip) SetSizes() (int, int) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.infected), len(v.removed)
+}
 func (v *VivaldiGossip) GetNeighbour() (m.Coordinate, bool) {
 	return v.store.GetNeighbourCoords()
 }
+func (v *VivaldiGossip) Store() m.Store {
+	return v.store
+}
+
+// SetVivaldiProtocol sets the protocol updated with the RTT of the gossip rounds, which is built after the gossip one
+func (v *VivaldiGossip) SetVivaldiProtocol(vivaldiProtocol *VivaldiProtocol) {
+	if v.vivaldiProtocol == nil {
+		v.vivaldiProtocol = vivaldiProtocol
+	}
+}
+
 func (v *VivaldiGossip) SetPartialView(view *m.PartialView) {
 	if v.pView == nil {
 		v.pView = view
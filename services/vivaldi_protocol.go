@@ -2,17 +2,19 @@ package services
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"github.com/AlessandroFinocchi/sdcc_common/pb"
 	u "github.com/AlessandroFinocchi/sdcc_common/utils"
 	"google.golang.org/grpc"
 	"log"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net"
-	"os"
+	"sdcc_host/config"
+	"sdcc_host/metrics"
 	m "sdcc_host/model"
+	"sdcc_host/results"
 	uh "sdcc_host/utils"
 	"sdcc_host/vivaldi"
 	"strconv"
@@ -22,59 +24,82 @@ import (
 
 type VivaldiProtocol struct {
 	pb.UnimplementedVivaldiServer
-	sysCoord          m.Coordinate
-	error             float64
-	pView             *m.PartialView
-	cc                float64
-	ce                float64
-	filter            vivaldi.Filter
-	stabilizer        *Stabilizer
-	mu                *sync.RWMutex
-	logger            uh.MyLogger
-	round             int64
-	resultFileEnabled bool
-}
-
-func NewVivaldiProtocol(vivaldiGossip *VivaldiGossip, filter vivaldi.Filter) *VivaldiProtocol {
-	cc, err1 := u.ReadConfigFloat64("config.ini", "vivaldi", "cc")
-	ce, err2 := u.ReadConfigFloat64("config.ini", "vivaldi", "ce")
-	coordinateDimensions, err3 := u.ReadConfigInt("config.ini", "vivaldi", "coordinate_dimensions")
-	cs := u.ReadConfigString("config.ini", "vivaldi", "coordinate_space")
-	logging, errL := strconv.ParseBool(os.Getenv(m.LoggingVivaldiEnv))
-	resultFileEnabled, errR := strconv.ParseBool(os.Getenv(m.LoggingResultEnv))
-	if err1 != nil || err2 != nil || err3 != nil || errL != nil || errR != nil {
-		log.Fatalf("Failed to read config in vivaldi protcol: %v", err1)
-	}
-
-	switch cs {
-	case "euclidean":
-		m.InstanceSpace = m.EuclideanSpace{}
-		m.SpaceType = 1
-	case "height_euclidean":
-		m.InstanceSpace = m.HeightVectorEuclideanSpace{}
-		m.SpaceType = 2
-		coordinateDimensions += 1 // for height
-	}
+	sysCoord         m.Coordinate
+	space            m.Space
+	vectorSize       int // number of values of a coordinate of the space
+	error            float64
+	pView            *m.PartialView
+	server           *grpc.Server
+	cc               float64
+	ce               float64
+	gravityRho       float64      // distance in ms at which gravity pulls as much as 1 ms per update, 0 to disable
+	origin           m.Coordinate // point pulled by gravity, unused in the spherical space
+	samplingInterval time.Duration
+	filter           vivaldi.Filter
+	rttMode          string       // "grpc", "udp" or "tcp_info"
+	prober           *Prober      // measures the RTTs in udp mode, nil otherwise
+	probeServer      *ProbeServer // answers the probes in udp mode, nil otherwise
+	probePort        int          // port of the probe servers, 0 for the number of the vivaldi port
+	kernelMaxRttVar  float64      // maximum ratio of the kernel RTT variation to the kernel RTT, 0 for no maximum
+	stabilizer       *Stabilizer
+	mu               *sync.RWMutex
+	logger           *slog.Logger
+	resultSink       results.ResultSink
+	metrics          *metrics.Metrics
+}
+
+// VivaldiState is the state of the vivaldi protocol saved across restarts
+type VivaldiState struct {
+	Coordinate []float64       `json:"coordinate"`
+	Error      float64         `json:"error"`
+	Stabilizer StabilizerState `json:"stabilizer"`
+}
+
+func NewVivaldiProtocol(cfg *config.HostConfig, space m.Space, vivaldiGossip *VivaldiGossip, filter vivaldi.Filter,
+	logger *slog.Logger, mt *metrics.Metrics, resultSink results.ResultSink) *VivaldiProtocol {
+	coordinateDimensions := space.VectorSize(cfg.Vivaldi.CoordinateDimensions)
 
 	randomSlice := make([]float64, coordinateDimensions)
 	for i := range randomSlice {
 		randomSlice[i] = rand.Float64()
 	}
 
-	sysCoord := m.InstanceSpace.NewCoordinate(randomSlice)
+	sysCoord := space.NewCoordinate(randomSlice)
+
+	rttMode := cfg.Vivaldi.RttMode
+	var prober *Prober
+	var probeServer *ProbeServer
+	switch {
+	case rttMode == "udp":
+		prober = NewProber(cfg.Vivaldi)
+		probeServer = NewProbeServer(logger)
+	case rttMode == "tcp_info" && !m.TcpInfoSupported:
+		logger.Warn("kernel RTT is not available on this platform, timing the coordinate pulls instead")
+		rttMode = "grpc"
+	}
 
 	return &VivaldiProtocol{
-		sysCoord:          sysCoord,
-		error:             1,
-		pView:             nil,
-		cc:                cc,
-		ce:                ce,
-		filter:            filter,
-		stabilizer:        NewStabilizer(vivaldiGossip),
-		mu:                &sync.RWMutex{},
-		logger:            uh.NewMyLogger(logging),
-		round:             0,
-		resultFileEnabled: resultFileEnabled,
+		sysCoord:         sysCoord,
+		space:            space,
+		vectorSize:       coordinateDimensions,
+		error:            1,
+		pView:            nil,
+		cc:               cfg.Vivaldi.Cc,
+		ce:               cfg.Vivaldi.Ce,
+		gravityRho:       cfg.Vivaldi.GravityRho,
+		origin:           space.NewCoordinate(make([]float64, coordinateDimensions)),
+		samplingInterval: cfg.Vivaldi.SamplingInterval,
+		filter:           filter,
+		rttMode:          rttMode,
+		prober:           prober,
+		probeServer:      probeServer,
+		probePort:        cfg.Vivaldi.ProbePort,
+		kernelMaxRttVar:  cfg.Vivaldi.KernelMaxRttVar,
+		stabilizer:       NewStabilizer(cfg, space, vivaldiGossip, vivaldiGossip.logger, resultSink),
+		mu:               &sync.RWMutex{},
+		logger:           logger,
+		resultSink:       resultSink,
+		metrics:          mt,
 	}
 
 }
@@ -90,73 +115,215 @@ func (v *VivaldiProtocol) PullCoordinates(ctx context.Context, _ *pb.Empty) (*pb
 	return v.sysCoord.Proto(v.error), nil
 }
 
-func (v *VivaldiProtocol) StartServer() (string, uint32) {
-	flag.Parse()
-	serverAddress := fmt.Sprintf(":%d", *m.VivaldiPort)
-	lis, err := net.Listen("tcp", serverAddress)
+// StartServer starts serving on the given port (0 for an ephemeral one) and returns the address of the server
+func (v *VivaldiProtocol) StartServer(port uint) (string, uint32, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Fatalf("Failed to create listener: %v", err)
+		return "", 0, fmt.Errorf("failed to create vivaldi listener: %w", err)
 	}
 
 	serverIp, err := u.GetIpFromListener(lis)
 	if err != nil {
-		log.Fatalf("Failed to get IP from listener: %v", err)
+		_ = lis.Close()
+		return "", 0, fmt.Errorf("failed to get IP from vivaldi listener: %w", err)
 	}
-	serverPort := uint32(*m.VivaldiPort)
+	serverPort := uint32(lis.Addr().(*net.TCPAddr).Port)
 
-	registry := grpc.NewServer()
-	pb.RegisterVivaldiServer(registry, v)
+	if v.probeServer != nil {
+		if err = v.probeServer.Start(v.probeServerPort(serverPort)); err != nil {
+			_ = lis.Close()
+			return "", 0, err
+		}
+	}
+
+	v.server = grpc.NewServer()
+	pb.RegisterVivaldiServer(v.server, v)
 
 	go func() {
-		err = registry.Serve(lis)
-		if err != nil {
-			log.Fatalf("Failed to serve: %v", err)
+		if errS := v.server.Serve(lis); errS != nil {
+			log.Fatalf("Failed to serve: %v", errS)
 		}
 	}()
 
-	return serverIp, serverPort
+	return serverIp, serverPort, nil
 }
 
-func (v *VivaldiProtocol) StartClient() {
+func (v *VivaldiProtocol) StartClient(ctx context.Context) {
 	if v.pView == nil {
 		log.Fatalf("Partial view is not initialized")
 	}
 
-	samplingInterval, err := u.ReadConfigInt("config.ini", "vivaldi", "sampling_interval")
-	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
+	// Distribute the coordinates
+	ticker := time.NewTicker(v.samplingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v.sample(ctx)
+		}
 	}
+}
 
-	// Distribute the coordinates
-	ticker := time.NewTicker(time.Duration(samplingInterval) * time.Second)
-	for range ticker.C {
-		desc, ok := v.pView.GetRandomDescriptor()
-		if ok {
-			startTime := time.Now().In(m.Location)
-			coords, errV := desc.PullCoordinates()
-			rtt := time.Since(startTime)
-			if errV != nil {
-				v.logger.Log(fmt.Sprintf("Failed to pull coordinates: %v", errV))
-				v.pView.RemoveDescriptor(desc)
-			} else {
-				// Update the local coordinates
-				rttFiltered, rttPredicted := v.UpdateCoordinates(coords, rtt, desc.GetReceiverNode().GetId())
-
-				// Update the stabilizer
-				v.stabilizer.Update(&v.sysCoord, v.pView.GetCurrentServerNode())
-
-				// Log the results
-				v.writeFileResult()
-
-				// log
-				v.logger.Log(fmt.Sprintf("RTT filtered: %f", rttFiltered))
-				v.logger.Log(fmt.Sprintf("RTT predicted: %f", rttPredicted))
-				v.logger.Log(fmt.Sprintf("Error: %f", v.error))
-				v.logger.Log(fmt.Sprintf("Updated system coordinates: %v \n", v.sysCoord.Proto(0).Value))
-				_ = os.Stdout.Sync()
-			}
+func (v *VivaldiProtocol) sample(ctx context.Context) {
+	desc, ok := v.pView.GetRandomDescriptor()
+	if !ok {
+		return
+	}
+
+	startTime := time.Now().In(m.Location)
+	coords, errV := desc.PullCoordinates(ctx)
+	rtt := time.Since(startTime)
+	if ctx.Err() != nil { // the host is shutting down, the peer is not faulty
+		return
+	}
+	if errV != nil {
+		v.logger.Warn("failed to pull coordinates", uh.PeerId(desc.GetReceiverNode().GetId()), uh.Err(errV))
+		v.metrics.RpcFailed(metrics.RpcPull)
+		v.pView.RemoveDescriptor(desc)
+		return
+	}
+
+	// Besides grpc mode, the pull only tells that the peer is alive and the RTT is measured without the gRPC overhead
+	node := desc.GetReceiverNode()
+	switch v.rttMode {
+	case "udp":
+		address := net.JoinHostPort(node.GetVivaldiIp(), strconv.Itoa(int(v.probeServerPort(node.GetVivaldiPort()))))
+		probeRtt, errP := v.prober.Probe(ctx, address)
+		if ctx.Err() != nil {
+			return
+		}
+		if errP != nil {
+			v.logger.Warn("failed to probe peer", uh.PeerId(node.GetId()), uh.Err(errP))
+			v.metrics.RpcFailed(metrics.RpcProbe)
+			return
+		}
+		rtt = probeRtt
+	case "tcp_info":
+		kernelRtt, kernelRttVar, errK := desc.KernelRtt()
+		if errK != nil {
+			v.logger.Warn("failed to read kernel RTT", uh.PeerId(node.GetId()), uh.Err(errK))
+			return
 		}
+		if !v.acceptKernelRtt(node.GetId(), kernelRtt, kernelRttVar) {
+			return
+		}
+		rtt = kernelRtt
 	}
+
+	v.AddSample(ctx, metrics.ProtocolVivaldi, desc.GetReceiverNode().GetId(), coords, rtt, startTime)
+}
+
+// acceptKernelRtt tells whether the smoothed RTT read from the kernel is a sample the filter can use: the kernel has no
+// sample before the first ACK, and its smoothed RTT is unreliable while the RTT variation is large compared to it, as
+// after the first samples or a change of route
+func (v *VivaldiProtocol) acceptKernelRtt(peerId string, kernelRtt, kernelRttVar time.Duration) bool {
+	if kernelRtt <= 0 {
+		v.logger.Debug("kernel RTT not measured yet", uh.PeerId(peerId))
+		return false
+	}
+	if v.kernelMaxRttVar > 0 && float64(kernelRttVar) > v.kernelMaxRttVar*float64(kernelRtt) {
+		v.logger.Debug("skipped kernel RTT with a large variation", uh.PeerId(peerId), uh.Rtt(kernelRtt),
+			slog.Float64("rtt_var_ms", uh.Milliseconds(kernelRttVar)))
+		return false
+	}
+	return true
+}
+
+// probeServerPort returns the port of the probe server of a host whose vivaldi server listens on vivaldiPort
+func (v *VivaldiProtocol) probeServerPort(vivaldiPort uint32) uint32 {
+	if v.probePort == 0 {
+		return vivaldiPort // the probe server uses UDP, so it can share the number of the vivaldi port
+	}
+	return uint32(v.probePort)
+}
+
+// AddSample updates the system coordinate with the RTT to the peer measured by the given protocol at startTime, and
+// then the stabilizer, recording the sample in the metrics and in the results. Besides grpc mode, the RTTs measured by
+// the other protocols are only recorded in the metrics, since they include the gRPC overhead the vivaldi ones leave out.
+func (v *VivaldiProtocol) AddSample(ctx context.Context, protocol string, peerId string, coords *pb.VivaldiCoordinate,
+	rtt time.Duration, startTime time.Time) {
+	if v.rttMode != "grpc" && protocol != metrics.ProtocolVivaldi {
+		v.metrics.ObserveRawRtt(protocol, rtt)
+		return
+	}
+	if len(coords.GetValue()) != v.vectorSize {
+		v.logger.Warn("ignored sample with a coordinate of a different size", uh.PeerId(peerId),
+			slog.String("protocol", protocol), slog.Int("size", len(coords.GetValue())))
+		return
+	}
+
+	// Update the local coordinates
+	update, ok := v.UpdateCoordinates(coords, rtt, peerId)
+	if !ok {
+		v.logger.Debug("ignored sample with a non-positive filtered RTT", uh.PeerId(peerId),
+			slog.String("protocol", protocol), uh.Rtt(rtt))
+		v.metrics.ObserveRawRtt(protocol, rtt)
+		return
+	}
+	rttFiltered, rttPredicted, coord, coordErr := update.RttFiltered, update.RttPredicted, update.Coordinate, update.Error
+	v.metrics.ObserveRtt(protocol, rtt, uh.DurationFromMs(rttFiltered))
+	v.metrics.ObservePredictionError(math.Abs(rttPredicted-rttFiltered) / rttFiltered)
+
+	// Update the stabilizer with the copy of the coordinate moved by this sample, without holding the lock, since the
+	// stabilizer gossips the application coordinate and the gossip server locks the vivaldi protocol to send the
+	// coordinate header
+	v.stabilizer.Update(&coord, v.pView.GetCurrentServerNode())
+
+	// Record the results
+	errR := v.resultSink.WriteSample(results.Sample{
+		Time:        startTime,
+		PeerId:      peerId,
+		RawRtt:      rtt,
+		FilteredRtt: uh.DurationFromMs(rttFiltered),
+		Predicted:   rttPredicted,
+		Coordinate:  coord.Proto(coordErr).Value,
+		Error:       coordErr,
+	})
+	if errR != nil {
+		v.logger.Error("failed to write result", uh.Err(errR))
+	}
+
+	if v.logger.Enabled(ctx, slog.LevelDebug) {
+		v.logger.Debug("updated system coordinates",
+			slog.String("protocol", protocol),
+			uh.PeerId(peerId),
+			uh.Rtt(rtt),
+			slog.Float64("rtt_filtered_ms", rttFiltered),
+			slog.Float64("rtt_predicted_ms", rttPredicted),
+			slog.Float64("coord_error", coordErr),
+			slog.Any("coord", coord.Proto(0).Value))
+	}
+}
+
+// Stop gracefully stops the vivaldi server, waiting for the pending requests
+func (v *VivaldiProtocol) Stop() {
+	if v.server != nil {
+		v.server.GracefulStop()
+	}
+	if v.probeServer != nil {
+		v.probeServer.Stop()
+	}
+}
+
+// Coordinate returns a copy of the current system coordinate and its error
+func (v *VivaldiProtocol) Coordinate() (m.Coordinate, float64) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.copyCoordinate(), v.error
+}
+
+// copyCoordinate returns a copy of the system coordinate, with the lock held
+func (v *VivaldiProtocol) copyCoordinate() m.Coordinate {
+	value := append([]float64(nil), v.sysCoord.Proto(v.error).Value...)
+	return v.space.NewCoordinate(value)
+}
+
+// AppCoordinate returns a copy of the current application coordinate and the last time it was gossiped
+func (v *VivaldiProtocol) AppCoordinate() (m.Coordinate, time.Time) {
+	return v.stabilizer.AppCoordinate()
 }
 
 func (v *VivaldiProtocol) SetPartialView(view *m.PartialView) {
@@ -165,14 +332,29 @@ func (v *VivaldiProtocol) SetPartialView(view *m.PartialView) {
 	}
 }
 
-func (v *VivaldiProtocol) UpdateCoordinates(receivedProtoCoordinates *pb.VivaldiCoordinate, rtt time.Duration, receiverNodeId string) (float64, float64) {
+// SampleUpdate is the outcome of a sample applied to the system coordinate. It holds the coordinate and the error right
+// after the sample, since the samples of the other protocols may move them before they are read again.
+type SampleUpdate struct {
+	RttFiltered  float64      // filtered RTT in ms
+	RttPredicted float64      // RTT in ms predicted by the coordinates before moving
+	Coordinate   m.Coordinate // copy of the system coordinate after moving
+	Error        float64      // error of the system coordinate after moving
+}
+
+// UpdateCoordinates moves the system coordinate with a sample of the RTT to the remote coordinate. The sample is
+// ignored, returning false, when the filtered RTT is not positive, since the relative error of the prediction would not
+// be defined.
+func (v *VivaldiProtocol) UpdateCoordinates(receivedProtoCoordinates *pb.VivaldiCoordinate, rtt time.Duration, receiverNodeId string) (SampleUpdate, bool) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	remoteCoordinate := m.InstanceSpace.Proto2Coordinate(receivedProtoCoordinates)
+	remoteCoordinate := v.space.Proto2Coordinate(receivedProtoCoordinates)
 	remoteError := receivedProtoCoordinates.GetError()
-	rttFiltered := float64(v.filter.FilterCoordinates(receiverNodeId, rtt).Milliseconds())
-	norm2Dist := m.InstanceSpace.GetNorm2Distance(v.sysCoord, remoteCoordinate)
+	rttFiltered := uh.Milliseconds(v.filter.FilterCoordinates(receiverNodeId, rtt))
+	norm2Dist := v.space.GetNorm2Distance(v.sysCoord, remoteCoordinate)
+	if rttFiltered <= 0 {
+		return SampleUpdate{RttFiltered: rttFiltered, RttPredicted: norm2Dist}, false
+	}
 
 	// Sample weight balances local and remote confidences
 	w := v.error / (v.error + remoteError)
@@ -187,27 +369,64 @@ func (v *VivaldiProtocol) UpdateCoordinates(receivedProtoCoordinates *pb.Vivaldi
 	// Update the local coordinates
 	delta := v.cc * w
 	multiplier := delta * (rttFiltered - norm2Dist)
-	unitV := m.InstanceSpace.Subtract(v.sysCoord, remoteCoordinate).GetUnitVector()
-	shift := m.InstanceSpace.Multiply(unitV, multiplier)
-	v.sysCoord = m.InstanceSpace.Add(v.sysCoord, shift)
+	unitV := v.space.Subtract(v.sysCoord, remoteCoordinate).GetUnitVector()
+	shift := v.space.Multiply(unitV, multiplier)
+	v.sysCoord = v.space.Add(v.sysCoord, shift)
+
+	if v.gravityRho > 0 {
+		v.applyGravity()
+	}
 
-	return rttFiltered, norm2Dist
+	return SampleUpdate{
+		RttFiltered:  rttFiltered,
+		RttPredicted: norm2Dist,
+		Coordinate:   v.copyCoordinate(),
+		Error:        v.error,
+	}, true
 }
 
-func (v *VivaldiProtocol) writeFileResult() {
-	if !v.resultFileEnabled {
-		return
+// applyGravity pulls the system coordinate toward the origin by (d/rho)^2, with d its distance from the origin, to keep
+// the coordinates of the whole system from drifting away together, as in "Network Coordinates in the Wild". The pull
+// never overshoots the origin.
+func (v *VivaldiProtocol) applyGravity() {
+	distance := v.space.GetNorm2Distance(v.sysCoord, v.origin)
+	pull := math.Min(math.Pow(distance/v.gravityRho, 2), distance)
+
+	unitV := v.space.Subtract(v.sysCoord, v.origin).GetUnitVector()
+	shift := v.space.Multiply(unitV, -pull)
+	v.sysCoord = v.space.Add(v.sysCoord, shift)
+}
+
+// State returns a copy of the system coordinate, of its error and of the stabilizer state
+func (v *VivaldiProtocol) State() VivaldiState {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return VivaldiState{
+		Coordinate: append([]float64(nil), v.sysCoord.Proto(v.error).Value...),
+		Error:      v.error,
+		Stabilizer: v.stabilizer.State(),
 	}
-	file, errO := os.OpenFile("/data/results.csv", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0664) // Write the file to /data (mapped to a volume)
-	if errO != nil {
-		v.logger.Log(fmt.Sprintf("Error opening file: %v", errO))
-	} else {
-		_, errW := file.WriteString(fmt.Sprintf("%d, %f\n", v.round, v.error))
-		if errW != nil {
-			v.logger.Log(fmt.Sprintf("Error writing to file: %v", errW))
-		} else {
-			v.round++
-		}
+}
+
+// Restore replaces the system coordinate, its error and the stabilizer state with the saved ones, ignoring a
+// coordinate with the wrong number of values
+func (v *VivaldiProtocol) Restore(state VivaldiState) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(state.Coordinate) == v.vectorSize {
+		v.sysCoord = v.space.NewCoordinate(append([]float64(nil), state.Coordinate...))
+		v.error = math.Min(math.Max(state.Error, 0), 1)
 	}
-	_ = file.Close()
+	v.stabilizer.Restore(state.Stabilizer)
+}
+
+// DistanceFromOrigin returns the distance in ms of the system coordinate from the origin of the space, which the
+// spherical space does not have
+func (v *VivaldiProtocol) DistanceFromOrigin() float64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.space.GetNorm2Distance(v.sysCoord, v.origin)
 }
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	m "sdcc_host/model"
+	"sdcc_host/vivaldi"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestProtocol(space m.Space, coord []float64) *VivaldiProtocol {
+	return &VivaldiProtocol{
+		sysCoord: space.NewCoordinate(coord),
+		space:    space,
+		error:    1,
+		cc:       0.25,
+		ce:       0.25,
+		filter:   &vivaldi.RawFilter{},
+		mu:       &sync.RWMutex{},
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+type updateTest struct {
+	name   string
+	local  []float64
+	remote []float64
+	rtt    time.Duration
+}
+
+// checkUpdatesTowardRtt checks that a sample brings the distance from the remote coordinate closer to the RTT, without
+// overshooting it
+func checkUpdatesTowardRtt(t *testing.T, space m.Space, tests []updateTest) {
+	t.Helper()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newTestProtocol(space, tc.local)
+			remote := space.NewCoordinate(tc.remote)
+			target := float64(tc.rtt) / float64(time.Millisecond)
+			before := space.GetNorm2Distance(v.sysCoord, remote)
+
+			update, ok := v.UpdateCoordinates(remote.Proto(1), tc.rtt, "remote")
+			if !ok {
+				t.Fatalf("sample was ignored")
+			}
+			coord, coordErr := v.Coordinate()
+			if !slices.Equal(update.Coordinate.Proto(0).Value, coord.Proto(0).Value) || update.Error != coordErr {
+				t.Errorf("update returned the coordinate %v with error %v, want %v with error %v",
+					update.Coordinate.Proto(0).Value, update.Error, coord.Proto(0).Value, coordErr)
+			}
+
+			after := space.GetNorm2Distance(v.sysCoord, remote)
+			if math.IsNaN(after) || math.IsNaN(v.error) || math.IsInf(v.error, 0) {
+				t.Fatalf("update produced a distance of %v and an error of %v", after, v.error)
+			}
+			if math.Abs(after-target) >= math.Abs(before-target) {
+				t.Errorf("distance went from %v to %v, not toward the RTT of %v", before, after, target)
+			}
+			if (before-target)*(after-target) < 0 {
+				t.Errorf("distance went from %v to %v, past the RTT of %v", before, after, target)
+			}
+		})
+	}
+}
+
+func TestSphericalUpdateMovesTowardRtt(t *testing.T) {
+	checkUpdatesTowardRtt(t, m.SphericalSpace{Radius: 80}, []updateTest{
+		{"too far", []float64{1, 0, 0}, []float64{0, 1, 0}, 40 * time.Millisecond},
+		{"too close", []float64{1, 0, 0}, []float64{0, 1, 0}, 200 * time.Millisecond},
+		{"close points too close", []float64{1, 0.01, 0}, []float64{1, 0, 0.01}, 30 * time.Millisecond},
+		{"beyond half circle", []float64{1, 0.1, 0}, []float64{-1, 0, 0.1}, 120 * time.Millisecond},
+	})
+}
+
+func TestHyperbolicUpdateMovesTowardRtt(t *testing.T) {
+	checkUpdatesTowardRtt(t, m.HyperbolicSpace{Curvature: -0.0004}, []updateTest{
+		{"too far", []float64{0, 0, 0}, []float64{0, 100, 0}, 40 * time.Millisecond},
+		{"too close", []float64{0, 0, 0}, []float64{0, 10, 10}, 80 * time.Millisecond},
+		{"far from the vertex", []float64{0, 300, -200}, []float64{0, 250, -150}, 150 * time.Millisecond},
+	})
+}
+
+func TestUpdateWithSubMillisecondRtt(t *testing.T) {
+	space := m.HeightVectorEuclideanSpace{}
+	checkUpdatesTowardRtt(t, space, []updateTest{
+		{"udp probe on a LAN", []float64{0, 0, 0, 0.1}, []float64{1, 1, 0, 0.1}, 300 * time.Microsecond},
+		{"kernel RTT on localhost", []float64{0, 0, 0, 0.01}, []float64{0.01, 0, 0, 0.01}, 40 * time.Microsecond},
+	})
+
+	v := newTestProtocol(space, []float64{1, 2, 3, 0.5})
+	before, beforeErr := v.Coordinate()
+	if _, ok := v.UpdateCoordinates(space.NewCoordinate([]float64{4, 5, 6, 0.5}).Proto(1), 0, "remote"); ok {
+		t.Fatalf("sample with a null RTT was not ignored")
+	}
+	after, afterErr := v.Coordinate()
+	if !slices.Equal(before.Proto(0).Value, after.Proto(0).Value) || beforeErr != afterErr {
+		t.Errorf("sample with a null RTT moved the coordinate from %v to %v", before.Proto(0).Value, after.Proto(0).Value)
+	}
+}
+
+func TestAcceptKernelRtt(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxRttVar float64
+		rtt       time.Duration
+		rttVar    time.Duration
+		accepted  bool
+	}{
+		{"not measured yet", 1, 0, 0, false},
+		{"stable", 1, 40 * time.Millisecond, 5 * time.Millisecond, true},
+		{"sub-millisecond", 1, 60 * time.Microsecond, 20 * time.Microsecond, true},
+		{"variation as large as the RTT", 1, 40 * time.Millisecond, 40 * time.Millisecond, true},
+		{"variation larger than the RTT", 1, 40 * time.Millisecond, 45 * time.Millisecond, false},
+		{"variation larger than the maximum", 0.5, 40 * time.Millisecond, 25 * time.Millisecond, false},
+		{"no maximum", 0, 40 * time.Millisecond, 200 * time.Millisecond, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newTestProtocol(m.EuclideanSpace{}, []float64{0, 0, 0})
+			v.kernelMaxRttVar = tc.maxRttVar
+			if accepted := v.acceptKernelRtt("remote", tc.rtt, tc.rttVar); accepted != tc.accepted {
+				t.Errorf("kernel RTT of %v ± %v accepted = %v, want %v", tc.rtt, tc.rttVar, accepted, tc.accepted)
+			}
+		})
+	}
+}
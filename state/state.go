@@ -0,0 +1,78 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/AlessandroFinocchi/sdcc_common/pb"
+	"os"
+	"path/filepath"
+	"sdcc_host/services"
+	"time"
+)
+
+var ErrStale = errors.New("saved state is stale")
+
+// Snapshot is the state of a host saved across restarts, so that a restarted host keeps its identity and does not
+// have to converge again from a random coordinate
+type Snapshot struct {
+	Time                 time.Time                  `json:"time"`
+	NodeId               string                     `json:"node_id"`
+	CoordinateSpace      string                     `json:"coordinate_space"`
+	CoordinateDimensions int                        `json:"coordinate_dimensions"`
+	Vivaldi              services.VivaldiState      `json:"vivaldi"`
+	FilterWindows        map[string][]time.Duration `json:"filter_windows,omitempty"` // RTT windows of the mp filter
+	View                 []*pb.Node                 `json:"view"`                     // nodes of the partial view
+}
+
+// Load reads the snapshot saved at path, returning an error wrapping os.ErrNotExist if there is none and ErrStale if
+// it was saved more than maxAge ago
+func Load(path string, maxAge time.Duration) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	snapshot := &Snapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if snapshot.NodeId == "" {
+		return nil, errors.New("state file has no node id")
+	}
+	if age := time.Since(snapshot.Time); age > maxAge {
+		return nil, fmt.Errorf("%w: saved %s ago", ErrStale, age.Round(time.Second))
+	}
+
+	return snapshot, nil
+}
+
+// Save writes the snapshot to path through a temporary file renamed over the previous one, so that a crash while
+// saving never leaves a truncated state behind
+func Save(path string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }() // fails once the file is renamed
+
+	_, errW := file.Write(data)
+	errS := file.Sync()
+	errC := file.Close()
+	if err = errors.Join(errW, errS, errC); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
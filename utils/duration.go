@@ -0,0 +1,13 @@
+package utils
+
+import "time"
+
+// Milliseconds returns the duration in milliseconds, with the fraction below the millisecond
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// DurationFromMs returns the duration of the given milliseconds, e.g. of an RTT predicted by the coordinates
+func DurationFromMs(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
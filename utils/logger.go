@@ -1,17 +1,99 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
-type MyLogger struct {
-	logging bool
+// Subsystems, each one with its own logger whose level can be set through the environment variable of the subsystem
+const (
+	SubsystemHost       = "host"
+	SubsystemMembership = "membership"
+	SubsystemVivaldi    = "vivaldi"
+	SubsystemGossip     = "gossip"
+	SubsystemRegistry   = "registry"
+)
+
+const (
+	LogFormatEnv = "LOG_FORMAT" // "json" (default) or "text"
+	LogLevelEnv  = "LOG_LEVEL"  // default level of every subsystem: "debug", "info" (default), "warn" or "error"
+)
+
+// subsystemEnvs contains the environment variables setting the level of each subsystem: either a level name, or a
+// boolean where true means "debug" and false means the default level
+var subsystemEnvs = map[string]string{
+	SubsystemHost:       "LOGGING",
+	SubsystemMembership: "MEMBERSHIP_LOGGING",
+	SubsystemVivaldi:    "VIVALDI_LOGGING",
+	SubsystemGossip:     "GOSSIPING_LOGGING",
+	SubsystemRegistry:   "LOGGING",
 }
 
-func NewMyLogger(logging bool) MyLogger {
-	return MyLogger{logging: logging}
+// Keys of the fields shared by the log records of every subsystem
+const (
+	KeySubsystem = "subsystem"
+	KeyNodeId    = "node_id"
+	KeyPeerId    = "peer_id"
+	KeyRtt       = "rtt_ms"
+	KeyError     = "error"
+)
+
+// NewLogger returns the logger of the given subsystem, configured through the environment
+func NewLogger(subsystem string) *slog.Logger {
+	level := readLevel(LogLevelEnv, slog.LevelInfo)
+	if env, ok := subsystemEnvs[subsystem]; ok {
+		level = readLevel(env, level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv(LogFormatEnv), "text") {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+
+	return slog.New(handler).With(KeySubsystem, subsystem)
 }
 
-func (l *MyLogger) Log(message string) {
-	if l.logging {
-		fmt.Println(message)
+// readLevel reads a level from the environment variable, falling back to def when it is unset or invalid
+func readLevel(env string, def slog.Level) slog.Level {
+	value, ok := os.LookupEnv(env)
+	if !ok || value == "" {
+		return def
 	}
+
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		if enabled {
+			return slog.LevelDebug
+		}
+		return def
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid log level %s=%q, using %s\n", env, value, def)
+		return def
+	}
+	return level
+}
+
+func NodeId(id string) slog.Attr {
+	return slog.String(KeyNodeId, id)
+}
+
+func PeerId(id string) slog.Attr {
+	return slog.String(KeyPeerId, id)
+}
+
+func Rtt(rtt time.Duration) slog.Attr {
+	return slog.Float64(KeyRtt, Milliseconds(rtt))
+}
+
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
 }
@@ -1,9 +1,7 @@
 package vivaldi
 
 import (
-	"fmt"
-	"github.com/AlessandroFinocchi/sdcc_common/utils"
-	"log"
+	"sdcc_host/config"
 	"slices"
 	"sync"
 	"time"
@@ -13,34 +11,22 @@ type Filter interface {
 	FilterCoordinates(string, time.Duration) time.Duration
 }
 
-func NewFilter() Filter {
-	filterType := utils.ReadConfigString("config.ini", "vivaldi", "filter_type")
-	switch filterType {
+func NewFilter(cfg config.VivaldiConfig) Filter {
+	switch cfg.FilterType {
 	case "mp":
-		fmt.Println("Using MP filter")
-		windowSize, err1 := utils.ReadConfigInt("config.ini", "vivaldi", "h")
-		p, err2 := utils.ReadConfigFloat64("config.ini", "vivaldi", "p")
-		if err1 != nil || err2 != nil {
-			log.Fatalf("Failed to read config: %v", err1)
-		}
 		return &MPFilter{
-			h:       windowSize,
-			p:       p,
+			h:       cfg.H,
+			p:       cfg.P,
 			windows: make(map[string][]time.Duration),
 			mu:      &sync.RWMutex{},
 		}
 	case "ewma":
-		fmt.Println("Using EWMA filter")
 		return &EWMAFilter{
-			alpha:        0.15,
-			currentValue: 0,
+			alpha:  0.15,
+			values: make(map[string]float64),
+			mu:     &sync.Mutex{},
 		}
-	case "raw":
-		fmt.Println("Using Raw filter")
-		return &RawFilter{}
-
-	default:
-		fmt.Println("Invalid filter type: using the default filter")
+	default: // "raw", the filter type is validated by the configuration
 		return &RawFilter{}
 	}
 }
@@ -53,8 +39,9 @@ type MPFilter struct {
 }
 
 type EWMAFilter struct {
-	alpha        float64
-	currentValue float64
+	alpha  float64
+	values map[string]float64 // average of every node, in nanoseconds not to round the sub-millisecond RTTs
+	mu     *sync.Mutex
 }
 
 type RawFilter struct {
@@ -82,9 +69,39 @@ func (mpf *MPFilter) FilterCoordinates(nodeId string, rtt time.Duration) time.Du
 	return samples[i]
 }
 
+// Windows returns a copy of the RTT window of every node
+func (mpf *MPFilter) Windows() map[string][]time.Duration {
+	mpf.mu.RLock()
+	defer mpf.mu.RUnlock()
+	windows := make(map[string][]time.Duration, len(mpf.windows))
+	for nodeId, window := range mpf.windows {
+		windows[nodeId] = slices.Clone(window)
+	}
+	return windows
+}
+
+// RestoreWindows replaces the RTT windows, keeping the latest h samples of each node
+func (mpf *MPFilter) RestoreWindows(windows map[string][]time.Duration) {
+	mpf.mu.Lock()
+	defer mpf.mu.Unlock()
+	mpf.windows = make(map[string][]time.Duration, len(windows))
+	for nodeId, window := range windows {
+		mpf.windows[nodeId] = slices.Clone(window[max(len(window)-mpf.h, 0):])
+	}
+}
+
+// FilterCoordinates averages the RTTs of each node on their own, starting from the first one, so that the average
+// of a new node is not pulled toward 0
 func (ef *EWMAFilter) FilterCoordinates(nodeId string, rtt time.Duration) time.Duration {
-	ef.currentValue = ef.alpha*float64(rtt.Milliseconds()) + (1-ef.alpha)*ef.currentValue
-	return time.Duration(ef.currentValue) * time.Millisecond
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	value, ok := ef.values[nodeId]
+	if !ok {
+		value = float64(rtt)
+	}
+	value = ef.alpha*float64(rtt) + (1-ef.alpha)*value
+	ef.values[nodeId] = value
+	return time.Duration(value)
 }
 
 func (rf *RawFilter) FilterCoordinates(nodeId string, rtt time.Duration) time.Duration {
@@ -0,0 +1,55 @@
+package vivaldi
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFiltersKeepSubMillisecondRtts(t *testing.T) {
+	filters := []struct {
+		name   string
+		filter Filter
+	}{
+		{"mp", &MPFilter{h: 4, p: 25, windows: make(map[string][]time.Duration), mu: &sync.RWMutex{}}},
+		{"ewma", &EWMAFilter{alpha: 0.15, values: make(map[string]float64), mu: &sync.Mutex{}}},
+		{"raw", &RawFilter{}},
+	}
+
+	for _, tc := range filters {
+		t.Run(tc.name, func(t *testing.T) {
+			var filtered time.Duration
+			for i := 0; i < 50; i++ {
+				filtered = tc.filter.FilterCoordinates("node", 400*time.Microsecond)
+			}
+			if filtered <= 0 || filtered > 400*time.Microsecond {
+				t.Errorf("filtered RTT is %v, want it in (0, 400µs]", filtered)
+			}
+		})
+	}
+}
+
+func TestFiltersKeepPeersApart(t *testing.T) {
+	filters := []struct {
+		name   string
+		filter Filter
+	}{
+		{"mp", &MPFilter{h: 4, p: 25, windows: make(map[string][]time.Duration), mu: &sync.RWMutex{}}},
+		{"ewma", &EWMAFilter{alpha: 0.15, values: make(map[string]float64), mu: &sync.Mutex{}}},
+		{"raw", &RawFilter{}},
+	}
+
+	near, far := 2*time.Millisecond, 200*time.Millisecond
+	for _, tc := range filters {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				if filtered := tc.filter.FilterCoordinates("near", near); filtered != near {
+					t.Fatalf("sample %d: filtered RTT of the near peer is %v, want %v", i, filtered, near)
+				}
+				if filtered := tc.filter.FilterCoordinates("far", far); filtered != far {
+					t.Fatalf("sample %d: filtered RTT of the far peer is %v, want %v", i, filtered, far)
+				}
+			}
+		})
+	}
+}